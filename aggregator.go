@@ -0,0 +1,425 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/essentialkaos/ek/v12/req"
+	"github.com/essentialkaos/ek/v12/timeutil"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Aggregator is data source which folds repeated gauges with the same name and
+// source (or tags) into one multi-sample gauge per sending period. Summaries
+// which weren't sent (after all retries) are dropped, errors are passed to
+// ErrorHandler.
+type Aggregator struct {
+	lastSendingDate int64 // must be first for 64-bit alignment of atomic operations
+	client          *Client
+	period          time.Duration
+	data            map[string]*aggregate
+	order           []string
+	dataMu          sync.Mutex
+
+	// Function executed if we have errors while sending data to Librato
+	ErrorHandler func(errs []error)
+	Engine       *req.Engine
+
+	// Top-level tags added to all tagged measurements
+	Tags Tags
+
+	// RetryPolicy is policy used for retrying failed sendings (if not set,
+	// client retry policy will be used)
+	RetryPolicy *RetryPolicy
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// aggregate contains summarized samples of one data stream
+type aggregate struct {
+	name   string
+	source string
+	tags   Tags
+	tagged bool
+
+	count      float64
+	sum        float64
+	min        float64
+	max        float64
+	sumSquares float64
+	last       float64
+
+	hasData       bool
+	hasLast       bool
+	hasMinMax     bool
+	hasSumSquares bool
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewAggregator creates new aggregator for async sending summarized gauges
+func NewAggregator(period time.Duration) *Aggregator {
+	return defaultClient.NewAggregator(period)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewAggregator creates new aggregator for async sending summarized gauges
+func (c *Client) NewAggregator(period time.Duration) *Aggregator {
+	aggregator := &Aggregator{
+		client:          c,
+		period:          period,
+		data:            make(map[string]*aggregate),
+		lastSendingDate: -1,
+	}
+
+	if UseGlobalEngine {
+		aggregator.Engine = c.getEngine()
+	} else {
+		aggregator.Engine = &req.Engine{}
+	}
+
+	registerSource(aggregator)
+
+	return aggregator
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Add adds gauges or tagged measurements to aggregation
+func (ag *Aggregator) Add(m ...Measurement) error {
+	for _, metric := range m {
+		err := metric.Validate()
+
+		if err != nil {
+			return err
+		}
+
+		switch metric.(type) {
+		case Gauge, TaggedMeasurement:
+		default:
+			return errors.New("Aggregator supports only gauges and tagged measurements")
+		}
+	}
+
+	ag.dataMu.Lock()
+	defer ag.dataMu.Unlock()
+
+	for _, metric := range m {
+		switch u := metric.(type) {
+		case Gauge:
+			ag.getAggregate(u.Name, u.Source, nil, false).addGauge(u)
+		case TaggedMeasurement:
+			ag.getAggregate(u.Name, "", u.Tags, true).addTagged(u)
+		}
+	}
+
+	return nil
+}
+
+// Send sends summarized data to Librato service
+func (ag *Aggregator) Send() []error {
+	return ag.SendContext(context.Background())
+}
+
+// SendContext sends summarized data to Librato service with given context
+func (ag *Aggregator) SendContext(ctx context.Context) []error {
+	if !ag.client.hasCredentials() {
+		return errAccessCredentials
+	}
+
+	err := validateTags(ag.Tags)
+
+	if err != nil {
+		return []error{err}
+	}
+
+	data := ag.takeMeasurements()
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	atomic.StoreInt64(&ag.lastSendingDate, time.Now().Unix())
+
+	errs, _, _ := ag.client.sendMeasurements(ctx, data, sendOptions{
+		engine:   ag.Engine,
+		policy:   ag.getRetryPolicy(),
+		tags:     ag.Tags,
+		throttle: true,
+	})
+
+	ag.execErrorHandler(errs)
+
+	return errs
+}
+
+// Flush synchronously sends all summarized data to Librato service
+func (ag *Aggregator) Flush(ctx context.Context) []error {
+	return ag.SendContext(ctx)
+}
+
+// Close removes aggregator from the list of data sources processed by sending loop
+func (ag *Aggregator) Close() {
+	unregisterSource(ag)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getPeriod return sending period
+func (ag *Aggregator) getPeriod() time.Duration {
+	return ag.period
+}
+
+// getLastSendingDate return last sending date
+func (ag *Aggregator) getLastSendingDate() int64 {
+	return atomic.LoadInt64(&ag.lastSendingDate)
+}
+
+// getRetryPolicy returns retry policy used for sending
+func (ag *Aggregator) getRetryPolicy() *RetryPolicy {
+	if ag.RetryPolicy != nil {
+		return ag.RetryPolicy
+	}
+
+	return ag.client.getRetryPolicy()
+}
+
+// execErrorHandler exec error handler if present
+func (ag *Aggregator) execErrorHandler(errs []error) {
+	if ag.ErrorHandler == nil || len(errs) == 0 {
+		return
+	}
+
+	ag.ErrorHandler(errs)
+}
+
+// getAggregate returns aggregate for data stream with given name and source
+// or tags
+func (ag *Aggregator) getAggregate(name, source string, tags Tags, tagged bool) *aggregate {
+	key := getAggregateKey(name, source, tags, tagged)
+	a, ok := ag.data[key]
+
+	if !ok {
+		a = &aggregate{name: name, source: source, tags: tags, tagged: tagged}
+		ag.data[key] = a
+		ag.order = append(ag.order, key)
+	}
+
+	return a
+}
+
+// takeMeasurements converts all aggregates to measurements and resets them
+func (ag *Aggregator) takeMeasurements() []Measurement {
+	ag.dataMu.Lock()
+
+	data, order := ag.data, ag.order
+	ag.data, ag.order = make(map[string]*aggregate), nil
+
+	ag.dataMu.Unlock()
+
+	period := int64(timeutil.DurationToSeconds(ag.period))
+	result := make([]Measurement, 0, len(order))
+
+	for _, key := range order {
+		if data[key].hasData {
+			result = append(result, data[key].toMeasurement(period))
+		}
+	}
+
+	return result
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// addGauge adds gauge samples to aggregate
+func (a *aggregate) addGauge(g Gauge) {
+	if g.Count == nil {
+		value, _ := toFloat(g.Value)
+		a.addSample(value)
+		return
+	}
+
+	count, _ := toFloat(g.Count)
+	sum, _ := toFloat(g.Sum)
+	min, hasMin := toFloat(g.Min)
+	max, hasMax := toFloat(g.Max)
+	sumSquares, hasSumSquares := toFloat(g.SumSquares)
+
+	a.addSummary(count, sum, min, max, sumSquares, hasMin && hasMax, hasSumSquares)
+}
+
+// addTagged adds tagged measurement samples to aggregate
+func (a *aggregate) addTagged(m TaggedMeasurement) {
+	if m.Count == nil {
+		value, _ := toFloat(m.Value)
+		a.addSample(value)
+		return
+	}
+
+	count, _ := toFloat(m.Count)
+	sum, _ := toFloat(m.Sum)
+	min, hasMin := toFloat(m.Min)
+	max, hasMax := toFloat(m.Max)
+
+	if !a.addSummary(count, sum, min, max, 0, hasMin && hasMax, false) {
+		return
+	}
+
+	last, hasLast := toFloat(m.Last)
+
+	if hasLast && isFinite(last) {
+		a.last, a.hasLast = last, true
+	}
+}
+
+// addSample adds single sample to aggregate
+func (a *aggregate) addSample(value float64) {
+	if a.addSummary(1, value, value, value, value*value, true, true) {
+		a.last, a.hasLast = value, true
+	}
+}
+
+// addSummary adds summary of samples to aggregate. Summaries without samples
+// or with non-finite values (NaN and ±Inf) are ignored.
+func (a *aggregate) addSummary(count, sum, min, max, sumSquares float64, hasMinMax, hasSumSquares bool) bool {
+	switch {
+	case count <= 0, !isFinite(count), !isFinite(sum):
+		return false
+	case hasMinMax && (!isFinite(min) || !isFinite(max)):
+		return false
+	case hasSumSquares && !isFinite(sumSquares):
+		return false
+	}
+
+	if !a.hasData {
+		a.hasData = true
+		a.hasMinMax, a.hasSumSquares = hasMinMax, hasSumSquares
+		a.min, a.max = min, max
+	} else {
+		a.hasMinMax = a.hasMinMax && hasMinMax
+		a.hasSumSquares = a.hasSumSquares && hasSumSquares
+		a.min, a.max = math.Min(a.min, min), math.Max(a.max, max)
+	}
+
+	a.count += count
+	a.sum += sum
+	a.sumSquares += sumSquares
+
+	return true
+}
+
+// toMeasurement converts aggregate to multi-sample measurement
+func (a *aggregate) toMeasurement(period int64) Measurement {
+	if a.tagged {
+		m := TaggedMeasurement{
+			Name:   a.name,
+			Tags:   a.tags,
+			Count:  a.count,
+			Sum:    a.sum,
+			Period: period,
+		}
+
+		if a.hasLast {
+			m.Last = a.last
+		}
+
+		if a.hasMinMax {
+			m.Min, m.Max = a.min, a.max
+		}
+
+		if a.hasSumSquares {
+			mean := a.sum / a.count
+			m.StdDev = math.Sqrt(math.Max(a.sumSquares/a.count-mean*mean, 0))
+		}
+
+		return m
+	}
+
+	g := Gauge{
+		Name:   a.name,
+		Source: a.source,
+		Count:  a.count,
+		Sum:    a.sum,
+	}
+
+	if a.hasMinMax {
+		g.Min, g.Max = a.min, a.max
+	}
+
+	if a.hasSumSquares {
+		g.SumSquares = a.sumSquares
+	}
+
+	return g
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getAggregateKey returns unique key of data stream
+func getAggregateKey(name, source string, tags Tags, tagged bool) string {
+	if !tagged {
+		return "g:" + name + "\x00" + source
+	}
+
+	names := make([]string, 0, len(tags))
+
+	for tagName := range tags {
+		names = append(names, tagName)
+	}
+
+	sort.Strings(names)
+
+	var buf strings.Builder
+
+	buf.WriteString("t:" + name)
+
+	for _, tagName := range names {
+		buf.WriteString("\x00" + tagName + "=" + tags[tagName])
+	}
+
+	return buf.String()
+}
+
+// isFinite returns true if given value is not NaN or ±Inf
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// toFloat converts numeric value to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch u := v.(type) {
+	case int:
+		return float64(u), true
+	case int32:
+		return float64(u), true
+	case int64:
+		return float64(u), true
+	case uint:
+		return float64(u), true
+	case uint32:
+		return float64(u), true
+	case uint64:
+		return float64(u), true
+	case float32:
+		return float64(u), true
+	case float64:
+		return u, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,503 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/essentialkaos/ek/v12/req"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Alert condition types
+const (
+	ALERT_CONDITION_ABOVE  = "above"
+	ALERT_CONDITION_BELOW  = "below"
+	ALERT_CONDITION_ABSENT = "absent"
+)
+
+// Alert statuses
+const (
+	ALERT_STATUS_OK        = "ok"
+	ALERT_STATUS_TRIGGERED = "triggered"
+)
+
+// SUMMARIZE_DERIVATIVE is summary function which can be used only in alert
+// conditions
+const SUMMARIZE_DERIVATIVE = "derivative"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Alert contains alert definition
+type Alert struct {
+	// Unique ID of the alert (set by API)
+	ID int64 `json:"id,omitempty"`
+
+	// Unique name of the alert
+	Name string `json:"name"`
+
+	// Description of the alert
+	Description string `json:"description,omitempty"`
+
+	// List of conditions which trigger the alert
+	Conditions []*AlertCondition `json:"conditions"`
+
+	// List of services notified when the alert is triggered. Only service IDs
+	// are used while creating or updating alert.
+	Services []*AlertService `json:"services"`
+
+	// Alert attributes
+	Attributes *AlertAttributes `json:"attributes,omitempty"`
+
+	// If false, the alert is disabled (if not set, alert is enabled on creation
+	// and keeps current state on update)
+	Active *bool `json:"active,omitempty"`
+
+	// Number of seconds the alert must wait before it can be triggered again
+	RearmSeconds int `json:"rearm_seconds,omitempty"`
+
+	// If true, the alert will rearm separately for every signal (source or tag
+	// set)
+	RearmPerSignal bool `json:"rearm_per_signal,omitempty"`
+
+	// Unix timestamps of creation and last update of the alert (set by API)
+	CreatedAt int64 `json:"created_at,omitempty"`
+	UpdatedAt int64 `json:"updated_at,omitempty"`
+}
+
+// AlertCondition contains alert condition
+type AlertCondition struct {
+	// Type of the condition (above, below or absent)
+	Type string `json:"type"`
+
+	// Name of the metric checked by the condition
+	MetricName string `json:"metric_name"`
+
+	// Source of the metric (wildcards are supported, only for source-based metrics)
+	Source string `json:"source,omitempty"`
+
+	// Tags used for filtering measurements (only for tagged metrics)
+	Tags []*AlertConditionTag `json:"tags,omitempty"`
+
+	// Threshold value (only for above and below conditions)
+	Threshold float64 `json:"threshold"`
+
+	// Function used for summarizing data (average, sum, count, min, max or
+	// derivative)
+	SummaryFunction string `json:"summary_function,omitempty"`
+
+	// Number of seconds the condition must be met before the alert is triggered
+	Duration int `json:"duration,omitempty"`
+
+	// If true, the alert will not be triggered by counter resets (only for
+	// derivative summary function)
+	DetectReset bool `json:"detect_reset,omitempty"`
+}
+
+// AlertConditionTag contains tag filter of alert condition
+type AlertConditionTag struct {
+	// Name of the tag
+	Name string `json:"name"`
+
+	// List of tag values (wildcards are supported)
+	Values []string `json:"values"`
+
+	// If true, measurements with different values of the tag will be checked
+	// together
+	Grouped bool `json:"grouped,omitempty"`
+}
+
+// AlertService contains info about service notified by alert
+type AlertService struct {
+	ID       int64             `json:"id"`
+	Type     string            `json:"type,omitempty"`
+	Title    string            `json:"title,omitempty"`
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// AlertAttributes contains additional info about alert
+type AlertAttributes struct {
+	// URL of the runbook for the alert
+	RunbookURL string `json:"runbook_url,omitempty"`
+}
+
+// ListAlertsOptions contains options of alerts listing
+type ListAlertsOptions struct {
+	Pagination
+
+	// Name is search string used for filtering alerts by name
+	Name string
+}
+
+// AlertsList contains page of alerts list
+type AlertsList struct {
+	Query  QueryInfo `json:"query"`
+	Alerts []*Alert  `json:"alerts"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type alertPayload struct {
+	Name           string            `json:"name"`
+	Description    string            `json:"description,omitempty"`
+	Conditions     []*AlertCondition `json:"conditions"`
+	Services       []int64           `json:"services"`
+	Attributes     *AlertAttributes  `json:"attributes,omitempty"`
+	Active         *bool             `json:"active,omitempty"`
+	RearmSeconds   int               `json:"rearm_seconds,omitempty"`
+	RearmPerSignal bool              `json:"rearm_per_signal,omitempty"`
+}
+
+type alertStatusResponse struct {
+	Status string `json:"status"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ListAlerts synchronously fetches list of alerts from librato
+func ListAlerts(opts ListAlertsOptions) (*AlertsList, []error) {
+	return defaultClient.ListAlerts(opts)
+}
+
+// ListAlertsContext synchronously fetches list of alerts from librato with
+// given context
+func ListAlertsContext(ctx context.Context, opts ListAlertsOptions) (*AlertsList, []error) {
+	return defaultClient.ListAlertsContext(ctx, opts)
+}
+
+// GetAlert synchronously fetches alert from librato
+func GetAlert(id int64) (*Alert, []error) {
+	return defaultClient.GetAlert(id)
+}
+
+// GetAlertContext synchronously fetches alert from librato with given context
+func GetAlertContext(ctx context.Context, id int64) (*Alert, []error) {
+	return defaultClient.GetAlertContext(ctx, id)
+}
+
+// CreateAlert synchronously creates alert on librato
+func CreateAlert(a Alert) (*Alert, []error) {
+	return defaultClient.CreateAlert(a)
+}
+
+// CreateAlertContext synchronously creates alert on librato with given context
+func CreateAlertContext(ctx context.Context, a Alert) (*Alert, []error) {
+	return defaultClient.CreateAlertContext(ctx, a)
+}
+
+// UpdateAlert synchronously updates alert on librato
+func UpdateAlert(a Alert) []error {
+	return defaultClient.UpdateAlert(a)
+}
+
+// UpdateAlertContext synchronously updates alert on librato with given context
+func UpdateAlertContext(ctx context.Context, a Alert) []error {
+	return defaultClient.UpdateAlertContext(ctx, a)
+}
+
+// DeleteAlert synchronously removes alert on librato
+func DeleteAlert(id int64) []error {
+	return defaultClient.DeleteAlert(id)
+}
+
+// DeleteAlertContext synchronously removes alert on librato with given context
+func DeleteAlertContext(ctx context.Context, id int64) []error {
+	return defaultClient.DeleteAlertContext(ctx, id)
+}
+
+// AlertStatus synchronously fetches status of alert (ok or triggered) from librato
+func AlertStatus(id int64) (string, []error) {
+	return defaultClient.AlertStatus(id)
+}
+
+// AlertStatusContext synchronously fetches status of alert (ok or triggered)
+// from librato with given context
+func AlertStatusContext(ctx context.Context, id int64) (string, []error) {
+	return defaultClient.AlertStatusContext(ctx, id)
+}
+
+// ResolveAlert synchronously clears triggered alert on librato
+func ResolveAlert(id int64) []error {
+	return defaultClient.ResolveAlert(id)
+}
+
+// ResolveAlertContext synchronously clears triggered alert on librato with
+// given context
+func ResolveAlertContext(ctx context.Context, id int64) []error {
+	return defaultClient.ResolveAlertContext(ctx, id)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ListAlerts synchronously fetches list of alerts from librato
+func (c *Client) ListAlerts(opts ListAlertsOptions) (*AlertsList, []error) {
+	return c.ListAlertsContext(context.Background(), opts)
+}
+
+// ListAlertsContext synchronously fetches list of alerts from librato with
+// given context
+func (c *Client) ListAlertsContext(ctx context.Context, opts ListAlertsOptions) (*AlertsList, []error) {
+	query := url.Values{"version": []string{"2"}}
+
+	opts.Pagination.apply(query)
+
+	if opts.Name != "" {
+		query.Set("name", opts.Name)
+	}
+
+	result := &AlertsList{}
+	errs := c.execJSONRequest(ctx, req.GET, "/v1/alerts", query, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// GetAlert synchronously fetches alert from librato
+func (c *Client) GetAlert(id int64) (*Alert, []error) {
+	return c.GetAlertContext(context.Background(), id)
+}
+
+// GetAlertContext synchronously fetches alert from librato with given context
+func (c *Client) GetAlertContext(ctx context.Context, id int64) (*Alert, []error) {
+	if id <= 0 {
+		return nil, errInvalidAlertID
+	}
+
+	result := &Alert{}
+	errs := c.execJSONRequest(ctx, req.GET, getAlertPath(id), nil, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// CreateAlert synchronously creates alert on librato
+func (c *Client) CreateAlert(a Alert) (*Alert, []error) {
+	return c.CreateAlertContext(context.Background(), a)
+}
+
+// CreateAlertContext synchronously creates alert on librato with given context
+func (c *Client) CreateAlertContext(ctx context.Context, a Alert) (*Alert, []error) {
+	err := a.Validate()
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	result := &Alert{}
+	errs := c.execJSONRequest(ctx, req.POST, "/v1/alerts", nil, a.toPayload(), result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// UpdateAlert synchronously updates alert on librato
+func (c *Client) UpdateAlert(a Alert) []error {
+	return c.UpdateAlertContext(context.Background(), a)
+}
+
+// UpdateAlertContext synchronously updates alert on librato with given context
+func (c *Client) UpdateAlertContext(ctx context.Context, a Alert) []error {
+	if a.ID <= 0 {
+		return errInvalidAlertID
+	}
+
+	err := a.Validate()
+
+	if err != nil {
+		return []error{err}
+	}
+
+	return c.execJSONRequest(ctx, req.PUT, getAlertPath(a.ID), nil, a.toPayload(), nil)
+}
+
+// DeleteAlert synchronously removes alert on librato
+func (c *Client) DeleteAlert(id int64) []error {
+	return c.DeleteAlertContext(context.Background(), id)
+}
+
+// DeleteAlertContext synchronously removes alert on librato with given context
+func (c *Client) DeleteAlertContext(ctx context.Context, id int64) []error {
+	if id <= 0 {
+		return errInvalidAlertID
+	}
+
+	return c.execJSONRequest(ctx, req.DELETE, getAlertPath(id), nil, nil, nil)
+}
+
+// AlertStatus synchronously fetches status of alert (ok or triggered) from librato
+func (c *Client) AlertStatus(id int64) (string, []error) {
+	return c.AlertStatusContext(context.Background(), id)
+}
+
+// AlertStatusContext synchronously fetches status of alert (ok or triggered)
+// from librato with given context
+func (c *Client) AlertStatusContext(ctx context.Context, id int64) (string, []error) {
+	if id <= 0 {
+		return "", errInvalidAlertID
+	}
+
+	result := &alertStatusResponse{}
+	errs := c.execJSONRequest(ctx, req.GET, getAlertPath(id)+"/status", nil, nil, result)
+
+	if len(errs) != 0 {
+		return "", errs
+	}
+
+	return result.Status, nil
+}
+
+// ResolveAlert synchronously clears triggered alert on librato
+func (c *Client) ResolveAlert(id int64) []error {
+	return c.ResolveAlertContext(context.Background(), id)
+}
+
+// ResolveAlertContext synchronously clears triggered alert on librato with
+// given context
+func (c *Client) ResolveAlertContext(ctx context.Context, id int64) []error {
+	if id <= 0 {
+		return errInvalidAlertID
+	}
+
+	return c.execJSONRequest(ctx, req.POST, getAlertPath(id)+"/clear", nil, nil, nil)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Validate validates alert struct
+func (a Alert) Validate() error {
+	return validateAlert(a)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// toPayload converts alert to payload of create and update requests
+func (a Alert) toPayload() alertPayload {
+	services := make([]int64, 0, len(a.Services))
+
+	for _, s := range a.Services {
+		services = append(services, s.ID)
+	}
+
+	return alertPayload{
+		Name:           a.Name,
+		Description:    a.Description,
+		Conditions:     a.Conditions,
+		Services:       services,
+		Attributes:     a.Attributes,
+		Active:         a.Active,
+		RearmSeconds:   a.RearmSeconds,
+		RearmPerSignal: a.RearmPerSignal,
+	}
+}
+
+// getAlertPath returns API path of alert
+func getAlertPath(id int64) string {
+	return "/v1/alerts/" + strconv.FormatInt(id, 10)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// validateAlert validate alert struct
+func validateAlert(a Alert) error {
+	if a.Name == "" {
+		return errors.New("Alert property Name can't be empty")
+	}
+
+	if len(a.Name) > 255 {
+		return errors.New("Length of alert property Name must be 255 or fewer characters")
+	}
+
+	if len(a.Conditions) == 0 {
+		return errors.New("Alert must have at least one condition")
+	}
+
+	for _, cond := range a.Conditions {
+		err := validateAlertCondition(cond)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range a.Services {
+		if s == nil || s.ID <= 0 {
+			return errors.New("Alert service ID must be greater than zero")
+		}
+	}
+
+	if a.RearmSeconds < 0 {
+		return errors.New("Alert property RearmSeconds can't be negative")
+	}
+
+	return nil
+}
+
+// validateAlertCondition validate alert condition struct
+func validateAlertCondition(c *AlertCondition) error {
+	if c == nil {
+		return errors.New("Alert condition can't be nil")
+	}
+
+	if c.MetricName == "" {
+		return errors.New("Alert condition property MetricName can't be empty")
+	}
+
+	switch c.Type {
+	case ALERT_CONDITION_ABOVE, ALERT_CONDITION_BELOW:
+	case ALERT_CONDITION_ABSENT:
+		if c.Duration < 60 {
+			return errors.New("Alert condition property Duration must be at least 60 seconds for absent conditions")
+		}
+	default:
+		return errors.New("Alert condition property Type contains unsupported condition type")
+	}
+
+	if c.Duration < 0 {
+		return errors.New("Alert condition property Duration can't be negative")
+	}
+
+	switch c.SummaryFunction {
+	case "", SUMMARIZE_AVERAGE, SUMMARIZE_SUM, SUMMARIZE_COUNT,
+		SUMMARIZE_MIN, SUMMARIZE_MAX, SUMMARIZE_DERIVATIVE:
+	default:
+		return errors.New("Alert condition property SummaryFunction contains unsupported function")
+	}
+
+	if c.DetectReset && c.SummaryFunction != SUMMARIZE_DERIVATIVE {
+		return errors.New("Alert condition property DetectReset can be used only with derivative function")
+	}
+
+	if c.Source != "" && len(c.Tags) != 0 {
+		return errors.New("Alert condition can't have both Source and Tags")
+	}
+
+	for _, tag := range c.Tags {
+		if tag == nil || tag.Name == "" {
+			return errors.New("Alert condition tag property Name can't be empty")
+		}
+
+		if len(tag.Values) == 0 {
+			return errors.New("Alert condition tag property Values can't be empty")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// AnnotationHandle is handle of annotation event with duration (e.g. deploy or
+// maintenance window) which end time is set by End method
+type AnnotationHandle struct {
+	client *Client
+	stream string
+	event  AnnotationEvent
+	file   string
+	mu     sync.Mutex
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type annotationState struct {
+	Stream string `json:"stream"`
+	ID     int64  `json:"id"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// BeginAnnotation synchronously creates annotation event with current time as
+// start time (if not set) and returns its handle
+func BeginAnnotation(stream string, a Annotation) (*AnnotationHandle, []error) {
+	return defaultClient.BeginAnnotation(stream, a)
+}
+
+// BeginAnnotationContext synchronously creates annotation event with current time
+// as start time (if not set) and returns its handle with given context
+func BeginAnnotationContext(ctx context.Context, stream string, a Annotation) (*AnnotationHandle, []error) {
+	return defaultClient.BeginAnnotationContext(ctx, stream, a)
+}
+
+// ResumeAnnotation synchronously fetches annotation event and returns its handle
+func ResumeAnnotation(stream string, id int64) (*AnnotationHandle, []error) {
+	return defaultClient.ResumeAnnotation(stream, id)
+}
+
+// ResumeAnnotationContext synchronously fetches annotation event and returns its
+// handle with given context
+func ResumeAnnotationContext(ctx context.Context, stream string, id int64) (*AnnotationHandle, []error) {
+	return defaultClient.ResumeAnnotationContext(ctx, stream, id)
+}
+
+// LoadAnnotation synchronously fetches annotation event saved to given file and
+// returns its handle
+func LoadAnnotation(file string) (*AnnotationHandle, []error) {
+	return defaultClient.LoadAnnotation(file)
+}
+
+// LoadAnnotationContext synchronously fetches annotation event saved to given file
+// and returns its handle with given context
+func LoadAnnotationContext(ctx context.Context, file string) (*AnnotationHandle, []error) {
+	return defaultClient.LoadAnnotationContext(ctx, file)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// BeginAnnotation synchronously creates annotation event with current time as
+// start time (if not set) and returns its handle
+func (c *Client) BeginAnnotation(stream string, a Annotation) (*AnnotationHandle, []error) {
+	return c.BeginAnnotationContext(context.Background(), stream, a)
+}
+
+// BeginAnnotationContext synchronously creates annotation event with current time
+// as start time (if not set) and returns its handle with given context
+func (c *Client) BeginAnnotationContext(ctx context.Context, stream string, a Annotation) (*AnnotationHandle, []error) {
+	if a.StartTime == 0 {
+		a.StartTime = time.Now().Unix()
+	}
+
+	event, errs := c.CreateAnnotationEventContext(ctx, stream, a)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return &AnnotationHandle{
+		client: c,
+		stream: stream,
+		event:  AnnotationEvent{ID: event.ID, Annotation: a},
+	}, nil
+}
+
+// ResumeAnnotation synchronously fetches annotation event and returns its handle
+func (c *Client) ResumeAnnotation(stream string, id int64) (*AnnotationHandle, []error) {
+	return c.ResumeAnnotationContext(context.Background(), stream, id)
+}
+
+// ResumeAnnotationContext synchronously fetches annotation event and returns its
+// handle with given context
+func (c *Client) ResumeAnnotationContext(ctx context.Context, stream string, id int64) (*AnnotationHandle, []error) {
+	event, errs := c.GetAnnotationEventContext(ctx, stream, id)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	event.ID = id
+
+	return &AnnotationHandle{client: c, stream: stream, event: *event}, nil
+}
+
+// LoadAnnotation synchronously fetches annotation event saved to given file and
+// returns its handle
+func (c *Client) LoadAnnotation(file string) (*AnnotationHandle, []error) {
+	return c.LoadAnnotationContext(context.Background(), file)
+}
+
+// LoadAnnotationContext synchronously fetches annotation event saved to given file
+// and returns its handle with given context
+func (c *Client) LoadAnnotationContext(ctx context.Context, file string) (*AnnotationHandle, []error) {
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	state := &annotationState{}
+	err = json.Unmarshal(data, state)
+
+	if err != nil {
+		return nil, []error{errors.New("Can't decode annotation state: " + err.Error())}
+	}
+
+	h, errs := c.ResumeAnnotationContext(ctx, state.Stream, state.ID)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	h.file = file
+
+	return h, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ID returns ID of annotation event
+func (h *AnnotationHandle) ID() int64 {
+	return h.event.ID
+}
+
+// Stream returns name of annotation stream
+func (h *AnnotationHandle) Stream() string {
+	return h.stream
+}
+
+// Save saves stream name and event ID to given file, so annotation can be ended
+// by another process with LoadAnnotation. File is removed after successful End.
+func (h *AnnotationHandle) Save(file string) error {
+	data, _ := json.Marshal(annotationState{Stream: h.stream, ID: h.event.ID})
+	err := ioutil.WriteFile(file, data, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.file = file
+	h.mu.Unlock()
+
+	return nil
+}
+
+// End synchronously sets end time of annotation event to current time
+func (h *AnnotationHandle) End(ctx context.Context) []error {
+	return h.EndAt(ctx, time.Now())
+}
+
+// EndAt synchronously sets end time of annotation event to given time
+func (h *AnnotationHandle) EndAt(ctx context.Context, t time.Time) []error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	a := h.event.Annotation
+	a.EndTime = t.Unix()
+
+	if a.EndTime < a.StartTime {
+		return []error{errors.New("Annotation end time can't be less than start time")}
+	}
+
+	errs := h.client.UpdateAnnotationEventContext(ctx, h.stream, h.event.ID, a)
+
+	if len(errs) != 0 {
+		return errs
+	}
+
+	h.event.Annotation = a
+
+	if h.file != "" {
+		err := os.Remove(h.file)
+
+		if err != nil && !os.IsNotExist(err) {
+			return []error{err}
+		}
+
+		h.file = ""
+	}
+
+	return nil
+}
@@ -0,0 +1,507 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/essentialkaos/ek/v12/req"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// UNASSIGNED_SOURCE is name of source used by API for events without source
+const UNASSIGNED_SOURCE = "unassigned"
+
+// DEFAULT_LINK_REL is rel of links defined as plain URLs
+const DEFAULT_LINK_REL = "link"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// AnnotationLink contains reference to resource associated with annotation
+type AnnotationLink struct {
+	// Relationship of the link to the annotation (e.g. github, jenkins)
+	Rel string `json:"rel"`
+
+	// Optional label of the link
+	Label string `json:"label,omitempty"`
+
+	// URL of the resource
+	Href string `json:"href"`
+}
+
+// AnnotationEvent contains annotation with its ID
+type AnnotationEvent struct {
+	ID int64 `json:"id"`
+
+	Annotation
+}
+
+// AnnotationStream contains annotation stream info
+type AnnotationStream struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name,omitempty"`
+
+	// List of events (only for GetAnnotationStream)
+	Events []*AnnotationEvent `json:"-"`
+}
+
+// ListAnnotationStreamsOptions contains options of annotation streams list request
+type ListAnnotationStreamsOptions struct {
+	Pagination
+
+	// Name is search string used for filtering streams by name
+	Name string
+}
+
+// AnnotationStreamsList contains page of annotation streams list
+type AnnotationStreamsList struct {
+	Query   QueryInfo           `json:"query"`
+	Streams []*AnnotationStream `json:"annotations"`
+}
+
+// AnnotationStreamOptions contains options of annotation stream request
+type AnnotationStreamOptions struct {
+	// Unix timestamp of the beginning of the time range
+	StartTime int64
+
+	// Unix timestamp of the end of the time range
+	EndTime int64
+
+	// List of sources used for filtering events
+	Sources []string
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type annotationStreamResponse struct {
+	Name        string                          `json:"name"`
+	DisplayName string                          `json:"display_name"`
+	Events      []map[string][]*AnnotationEvent `json:"events"`
+}
+
+type annotationStreamInfo struct {
+	DisplayName string `json:"display_name"`
+}
+
+type annotationJSON struct {
+	ID    int64            `json:"id,omitempty"`
+	Links []AnnotationLink `json:"links,omitempty"`
+
+	*annotationFields
+}
+
+// annotationFields is Annotation without JSON methods
+type annotationFields Annotation
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ListAnnotationStreams synchronously fetches list of annotation streams from
+// librato
+func ListAnnotationStreams(opts ListAnnotationStreamsOptions) (*AnnotationStreamsList, []error) {
+	return defaultClient.ListAnnotationStreams(opts)
+}
+
+// ListAnnotationStreamsContext synchronously fetches list of annotation streams
+// from librato with given context
+func ListAnnotationStreamsContext(ctx context.Context, opts ListAnnotationStreamsOptions) (*AnnotationStreamsList, []error) {
+	return defaultClient.ListAnnotationStreamsContext(ctx, opts)
+}
+
+// GetAnnotationStream synchronously fetches annotation stream with events
+// from librato
+func GetAnnotationStream(stream string, opts AnnotationStreamOptions) (*AnnotationStream, []error) {
+	return defaultClient.GetAnnotationStream(stream, opts)
+}
+
+// GetAnnotationStreamContext synchronously fetches annotation stream with
+// events from librato with given context
+func GetAnnotationStreamContext(ctx context.Context, stream string, opts AnnotationStreamOptions) (*AnnotationStream, []error) {
+	return defaultClient.GetAnnotationStreamContext(ctx, stream, opts)
+}
+
+// UpdateAnnotationStream synchronously updates display name of annotation
+// stream on librato
+func UpdateAnnotationStream(stream, displayName string) []error {
+	return defaultClient.UpdateAnnotationStream(stream, displayName)
+}
+
+// UpdateAnnotationStreamContext synchronously updates display name of
+// annotation stream on librato with given context
+func UpdateAnnotationStreamContext(ctx context.Context, stream, displayName string) []error {
+	return defaultClient.UpdateAnnotationStreamContext(ctx, stream, displayName)
+}
+
+// CreateAnnotationEvent synchronously sends annotation to librato and returns
+// created event
+func CreateAnnotationEvent(stream string, a Annotation) (*AnnotationEvent, []error) {
+	return defaultClient.CreateAnnotationEvent(stream, a)
+}
+
+// CreateAnnotationEventContext synchronously sends annotation to librato with
+// given context and returns created event
+func CreateAnnotationEventContext(ctx context.Context, stream string, a Annotation) (*AnnotationEvent, []error) {
+	return defaultClient.CreateAnnotationEventContext(ctx, stream, a)
+}
+
+// GetAnnotationEvent synchronously fetches annotation event from librato
+func GetAnnotationEvent(stream string, id int64) (*AnnotationEvent, []error) {
+	return defaultClient.GetAnnotationEvent(stream, id)
+}
+
+// GetAnnotationEventContext synchronously fetches annotation event from
+// librato with given context
+func GetAnnotationEventContext(ctx context.Context, stream string, id int64) (*AnnotationEvent, []error) {
+	return defaultClient.GetAnnotationEventContext(ctx, stream, id)
+}
+
+// UpdateAnnotationEvent synchronously updates annotation event on librato
+func UpdateAnnotationEvent(stream string, id int64, a Annotation) []error {
+	return defaultClient.UpdateAnnotationEvent(stream, id, a)
+}
+
+// UpdateAnnotationEventContext synchronously updates annotation event on
+// librato with given context
+func UpdateAnnotationEventContext(ctx context.Context, stream string, id int64, a Annotation) []error {
+	return defaultClient.UpdateAnnotationEventContext(ctx, stream, id, a)
+}
+
+// DeleteAnnotationEvent synchronously removes annotation event on librato
+func DeleteAnnotationEvent(stream string, id int64) []error {
+	return defaultClient.DeleteAnnotationEvent(stream, id)
+}
+
+// DeleteAnnotationEventContext synchronously removes annotation event on
+// librato with given context
+func DeleteAnnotationEventContext(ctx context.Context, stream string, id int64) []error {
+	return defaultClient.DeleteAnnotationEventContext(ctx, stream, id)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ListAnnotationStreams synchronously fetches list of annotation streams from
+// librato
+func (c *Client) ListAnnotationStreams(opts ListAnnotationStreamsOptions) (*AnnotationStreamsList, []error) {
+	return c.ListAnnotationStreamsContext(context.Background(), opts)
+}
+
+// ListAnnotationStreamsContext synchronously fetches list of annotation streams
+// from librato with given context
+func (c *Client) ListAnnotationStreamsContext(ctx context.Context, opts ListAnnotationStreamsOptions) (*AnnotationStreamsList, []error) {
+	query := url.Values{}
+
+	opts.Pagination.apply(query)
+
+	if opts.Name != "" {
+		query.Set("name", opts.Name)
+	}
+
+	result := &AnnotationStreamsList{}
+	errs := c.execJSONRequest(ctx, req.GET, "/v1/annotations", query, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// GetAnnotationStream synchronously fetches annotation stream with events
+// from librato
+func (c *Client) GetAnnotationStream(stream string, opts AnnotationStreamOptions) (*AnnotationStream, []error) {
+	return c.GetAnnotationStreamContext(context.Background(), stream, opts)
+}
+
+// GetAnnotationStreamContext synchronously fetches annotation stream with
+// events from librato with given context
+func (c *Client) GetAnnotationStreamContext(ctx context.Context, stream string, opts AnnotationStreamOptions) (*AnnotationStream, []error) {
+	if stream == "" {
+		return nil, errEmptyStreamName
+	}
+
+	query := url.Values{}
+
+	setQueryInt(query, "start_time", opts.StartTime)
+	setQueryInt(query, "end_time", opts.EndTime)
+
+	for _, source := range opts.Sources {
+		query.Add("sources[]", source)
+	}
+
+	resp := &annotationStreamResponse{}
+	errs := c.execJSONRequest(ctx, req.GET, getAnnotationPath(stream), query, nil, resp)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return &AnnotationStream{
+		Name:        resp.Name,
+		DisplayName: resp.DisplayName,
+		Events:      flattenAnnotationEvents(resp.Events),
+	}, nil
+}
+
+// UpdateAnnotationStream synchronously updates display name of annotation
+// stream on librato
+func (c *Client) UpdateAnnotationStream(stream, displayName string) []error {
+	return c.UpdateAnnotationStreamContext(context.Background(), stream, displayName)
+}
+
+// UpdateAnnotationStreamContext synchronously updates display name of
+// annotation stream on librato with given context
+func (c *Client) UpdateAnnotationStreamContext(ctx context.Context, stream, displayName string) []error {
+	if stream == "" {
+		return errEmptyStreamName
+	}
+
+	return c.execJSONRequest(
+		ctx, req.PUT, getAnnotationPath(stream), nil,
+		annotationStreamInfo{displayName}, nil,
+	)
+}
+
+// CreateAnnotationEvent synchronously sends annotation to librato and returns
+// created event
+func (c *Client) CreateAnnotationEvent(stream string, a Annotation) (*AnnotationEvent, []error) {
+	return c.CreateAnnotationEventContext(context.Background(), stream, a)
+}
+
+// CreateAnnotationEventContext synchronously sends annotation to librato with
+// given context and returns created event
+func (c *Client) CreateAnnotationEventContext(ctx context.Context, stream string, a Annotation) (*AnnotationEvent, []error) {
+	if stream == "" {
+		return nil, errEmptyStreamName
+	}
+
+	err := validateAnotation(a)
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	result := &AnnotationEvent{}
+	errs := c.execRequest(ctx, c.getEngine(), req.POST, getAnnotationPath(stream), a, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// GetAnnotationEvent synchronously fetches annotation event from librato
+func (c *Client) GetAnnotationEvent(stream string, id int64) (*AnnotationEvent, []error) {
+	return c.GetAnnotationEventContext(context.Background(), stream, id)
+}
+
+// GetAnnotationEventContext synchronously fetches annotation event from
+// librato with given context
+func (c *Client) GetAnnotationEventContext(ctx context.Context, stream string, id int64) (*AnnotationEvent, []error) {
+	switch {
+	case stream == "":
+		return nil, errEmptyStreamName
+	case id <= 0:
+		return nil, errInvalidEventID
+	}
+
+	result := &AnnotationEvent{}
+	errs := c.execJSONRequest(ctx, req.GET, getAnnotationEventPath(stream, id), nil, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// UpdateAnnotationEvent synchronously updates annotation event on librato
+func (c *Client) UpdateAnnotationEvent(stream string, id int64, a Annotation) []error {
+	return c.UpdateAnnotationEventContext(context.Background(), stream, id, a)
+}
+
+// UpdateAnnotationEventContext synchronously updates annotation event on
+// librato with given context
+func (c *Client) UpdateAnnotationEventContext(ctx context.Context, stream string, id int64, a Annotation) []error {
+	switch {
+	case stream == "":
+		return errEmptyStreamName
+	case id <= 0:
+		return errInvalidEventID
+	}
+
+	err := validateAnotation(a)
+
+	if err != nil {
+		return []error{err}
+	}
+
+	return c.execJSONRequest(ctx, req.PUT, getAnnotationEventPath(stream, id), nil, a, nil)
+}
+
+// DeleteAnnotationEvent synchronously removes annotation event on librato
+func (c *Client) DeleteAnnotationEvent(stream string, id int64) []error {
+	return c.DeleteAnnotationEventContext(context.Background(), stream, id)
+}
+
+// DeleteAnnotationEventContext synchronously removes annotation event on
+// librato with given context
+func (c *Client) DeleteAnnotationEventContext(ctx context.Context, stream string, id int64) []error {
+	switch {
+	case stream == "":
+		return errEmptyStreamName
+	case id <= 0:
+		return errInvalidEventID
+	}
+
+	return c.execJSONRequest(ctx, req.DELETE, getAnnotationEventPath(stream, id), nil, nil, nil)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Validate validates annotation link struct
+func (l AnnotationLink) Validate() error {
+	return validateAnnotationLink(l)
+}
+
+// UnmarshalJSON decodes link from JSON object or plain URL string
+func (l *AnnotationLink) UnmarshalJSON(data []byte) error {
+	var href string
+
+	if json.Unmarshal(data, &href) == nil {
+		*l = AnnotationLink{Rel: DEFAULT_LINK_REL, Href: href}
+		return nil
+	}
+
+	type link AnnotationLink
+
+	return json.Unmarshal(data, (*link)(l))
+}
+
+// MarshalJSON encodes annotation with all links as link objects
+func (a Annotation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(annotationJSON{
+		Links:            a.getLinks(),
+		annotationFields: (*annotationFields)(&a),
+	})
+}
+
+// UnmarshalJSON decodes annotation from JSON
+func (a *Annotation) UnmarshalJSON(data []byte) error {
+	v := annotationJSON{annotationFields: (*annotationFields)(a)}
+	err := json.Unmarshal(data, &v)
+
+	if err != nil {
+		return err
+	}
+
+	a.AnnotationLinks = v.Links
+
+	return nil
+}
+
+// MarshalJSON encodes annotation event with ID
+func (e AnnotationEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(annotationJSON{
+		ID:               e.ID,
+		Links:            e.getLinks(),
+		annotationFields: (*annotationFields)(&e.Annotation),
+	})
+}
+
+// UnmarshalJSON decodes annotation event from JSON
+func (e *AnnotationEvent) UnmarshalJSON(data []byte) error {
+	v := annotationJSON{annotationFields: (*annotationFields)(&e.Annotation)}
+	err := json.Unmarshal(data, &v)
+
+	if err != nil {
+		return err
+	}
+
+	e.ID, e.AnnotationLinks = v.ID, v.Links
+
+	return nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getLinks returns all annotation links including deprecated plain URLs
+func (a Annotation) getLinks() []AnnotationLink {
+	if len(a.Links) == 0 {
+		return a.AnnotationLinks
+	}
+
+	result := append([]AnnotationLink{}, a.AnnotationLinks...)
+
+	for _, href := range a.Links {
+		result = append(result, AnnotationLink{Rel: DEFAULT_LINK_REL, Href: href})
+	}
+
+	return result
+}
+
+// getAnnotationPath returns API path of annotation stream
+func getAnnotationPath(stream string) string {
+	return "/v1/annotations/" + url.PathEscape(stream)
+}
+
+// getAnnotationEventPath returns API path of annotation event
+func getAnnotationEventPath(stream string, id int64) string {
+	return getAnnotationPath(stream) + "/" + strconv.FormatInt(id, 10)
+}
+
+// flattenAnnotationEvents converts events grouped by source to list of events
+func flattenAnnotationEvents(data []map[string][]*AnnotationEvent) []*AnnotationEvent {
+	var result []*AnnotationEvent
+
+	for _, group := range data {
+		sources := make([]string, 0, len(group))
+
+		for source := range group {
+			sources = append(sources, source)
+		}
+
+		sort.Strings(sources)
+
+		for _, source := range sources {
+			for _, event := range group[source] {
+				if event.Source == "" && source != UNASSIGNED_SOURCE {
+					event.Source = source
+				}
+
+				result = append(result, event)
+			}
+		}
+	}
+
+	return result
+}
+
+// validateAnnotationLink validate annotation link struct
+func validateAnnotationLink(l AnnotationLink) error {
+	if l.Rel == "" {
+		return errors.New("Annotation link property Rel can't be empty")
+	}
+
+	if l.Href == "" {
+		return errors.New("Annotation link property Href can't be empty")
+	}
+
+	u, err := url.Parse(l.Href)
+
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.New("Annotation link property Href must be an absolute URL")
+	}
+
+	return nil
+}
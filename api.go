@@ -0,0 +1,78 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Pagination contains pagination parameters of list requests
+type Pagination struct {
+	Offset  int    // Index of the first item in the list
+	Length  int    // Maximum number of items in the list (100 by default)
+	OrderBy string // Name of property used for ordering
+	Sort    string // Sort order (asc/desc)
+}
+
+// QueryInfo contains pagination info of list responses
+type QueryInfo struct {
+	Found  int `json:"found"`
+	Length int `json:"length"`
+	Offset int `json:"offset"`
+	Total  int `json:"total"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// HasMore returns true if there are more items after current page
+func (q QueryInfo) HasMore() bool {
+	return q.Offset+q.Length < q.Found
+}
+
+// Next returns pagination parameters for the next page
+func (p Pagination) Next(q QueryInfo) Pagination {
+	p.Offset = q.Offset + q.Length
+	return p
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// apply adds pagination parameters to query
+func (p Pagination) apply(query url.Values) {
+	if p.Offset > 0 {
+		query.Set("offset", strconv.Itoa(p.Offset))
+	}
+
+	if p.Length > 0 {
+		query.Set("length", strconv.Itoa(p.Length))
+	}
+
+	if p.OrderBy != "" {
+		query.Set("orderby", p.OrderBy)
+	}
+
+	if p.Sort != "" {
+		query.Set("sort", p.Sort)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// execJSONRequest executes request to API using client engine and decodes
+// response to given result struct
+func (c *Client) execJSONRequest(ctx context.Context, method, path string, query url.Values, data, result interface{}) []error {
+	if len(query) != 0 {
+		path += "?" + query.Encode()
+	}
+
+	return c.execRequest(ctx, c.getEngine(), method, path, data, result)
+}
@@ -0,0 +1,240 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/essentialkaos/ek/v12/req"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+const (
+	// DEFAULT_MAX_BATCH_SIZE is default maximum number of measurements per request
+	DEFAULT_MAX_BATCH_SIZE = 300
+
+	// DEFAULT_MAX_PAYLOAD_SIZE is default maximum size of request body in bytes
+	DEFAULT_MAX_PAYLOAD_SIZE = 1024 * 1024
+)
+
+// payloadOverhead is approximate size of payload wrapper in bytes
+const payloadOverhead = 64
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Batch limits used by package-level methods
+var (
+	// DefaultBatchSize is maximum number of measurements per request
+	DefaultBatchSize = DEFAULT_MAX_BATCH_SIZE
+
+	// DefaultMaxPayloadSize is maximum size of request body in bytes
+	DefaultMaxPayloadSize = DEFAULT_MAX_PAYLOAD_SIZE
+
+	// DefaultMaxParallelRequests is maximum number of batches sent in parallel
+	DefaultMaxParallelRequests = 1
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// sendOptions contains options of measurements sending
+type sendOptions struct {
+	engine   *req.Engine
+	policy   *RetryPolicy
+	tags     Tags
+	throttle bool // Wait for rate limit reset before sending
+}
+
+// batch contains measurements sent by one request
+type batch struct {
+	path    string
+	payload interface{}
+	data    []Measurement
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// createBatches splits measurements to batches which respect request size limits
+func (c *Client) createBatches(data []Measurement, tags Tags) []batch {
+	var result []batch
+
+	legacy, tagged := splitMeasurements(data)
+	maxSize, maxPayload := c.getBatchLimits()
+
+	for _, chunk := range chunkMeasurements(legacy, maxSize, maxPayload) {
+		result = append(result, batch{
+			path:    "/v1/metrics/",
+			payload: convertMeasurementSlice(chunk),
+			data:    chunk,
+		})
+	}
+
+	maxPayload -= getEncodedSize(tags)
+
+	for _, chunk := range chunkMeasurements(tagged, maxSize, maxPayload) {
+		result = append(result, batch{
+			path:    "/v1/measurements",
+			payload: taggedMeasurements{Tags: tags, Measurements: extractTaggedMeasurements(chunk)},
+			data:    chunk,
+		})
+	}
+
+	return result
+}
+
+// sendBatches sends batches to API and returns errors, measurements which
+// weren't sent due to temporary failure and number of measurements rejected
+// due to permanent failure (client errors or encoding errors)
+func (c *Client) sendBatches(ctx context.Context, batches []batch, opts sendOptions) ([]error, []Measurement, int) {
+	var errs []error
+	var failed []Measurement
+	var rejected int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	limiter := make(chan struct{}, c.getMaxParallelRequests())
+
+	for _, b := range batches {
+		limiter <- struct{}{}
+		wg.Add(1)
+
+		go func(b batch) {
+			defer func() {
+				<-limiter
+				wg.Done()
+			}()
+
+			var statusCode int
+			var reqErrs []error
+
+			// Payload is encoded before sending, so encoding errors are not
+			// mistaken for temporary network failures
+			payload, err := json.Marshal(b.payload)
+			isPermanent := err != nil
+
+			if err != nil {
+				reqErrs = []error{err}
+			} else if err = c.throttle(ctx, opts.throttle); err != nil {
+				reqErrs = []error{err}
+			} else {
+				statusCode, reqErrs = c.execRequestWithPolicy(
+					ctx, opts.engine, opts.policy, req.POST, b.path, json.RawMessage(payload), nil,
+				)
+			}
+
+			if len(reqErrs) == 0 {
+				return
+			}
+
+			mu.Lock()
+
+			errs = append(errs, reqErrs...)
+
+			if !isPermanent && isTemporaryFailure(statusCode) {
+				failed = append(failed, b.data...)
+			} else {
+				rejected += len(b.data)
+			}
+
+			mu.Unlock()
+		}(b)
+	}
+
+	wg.Wait()
+
+	return errs, failed, rejected
+}
+
+// throttle waits for rate limit reset if throttling is enabled
+func (c *Client) throttle(ctx context.Context, enabled bool) error {
+	if !enabled {
+		return nil
+	}
+
+	return c.waitRateLimit(ctx)
+}
+
+// getBatchLimits returns maximum number of measurements and maximum payload
+// size per request
+func (c *Client) getBatchLimits() (int, int) {
+	maxSize, maxPayload := c.MaxBatchSize, c.MaxPayloadSize
+
+	if c.global {
+		maxSize, maxPayload = DefaultBatchSize, DefaultMaxPayloadSize
+	}
+
+	if maxSize <= 0 {
+		maxSize = DEFAULT_MAX_BATCH_SIZE
+	}
+
+	if maxPayload <= 0 {
+		maxPayload = DEFAULT_MAX_PAYLOAD_SIZE
+	}
+
+	return maxSize, maxPayload
+}
+
+// getMaxParallelRequests returns maximum number of batches sent in parallel
+func (c *Client) getMaxParallelRequests() int {
+	maxParallel := c.MaxParallelRequests
+
+	if c.global {
+		maxParallel = DefaultMaxParallelRequests
+	}
+
+	if maxParallel <= 0 {
+		return 1
+	}
+
+	return maxParallel
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// chunkMeasurements splits measurements to chunks with given maximum number of
+// measurements and maximum encoded size
+func chunkMeasurements(data []Measurement, maxSize, maxPayload int) [][]Measurement {
+	var result [][]Measurement
+	var chunk []Measurement
+	var chunkPayload int
+
+	for _, m := range data {
+		size := getEncodedSize(m) + 1
+
+		if len(chunk) != 0 && (len(chunk) >= maxSize || chunkPayload+size > maxPayload) {
+			result = append(result, chunk)
+			chunk, chunkPayload = nil, 0
+		}
+
+		if chunkPayload == 0 {
+			chunkPayload = payloadOverhead
+		}
+
+		chunk = append(chunk, m)
+		chunkPayload += size
+	}
+
+	if len(chunk) != 0 {
+		result = append(result, chunk)
+	}
+
+	return result
+}
+
+// getEncodedSize returns size of JSON-encoded value
+func getEncodedSize(v interface{}) int {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return 0
+	}
+
+	return len(data)
+}
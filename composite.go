@@ -0,0 +1,253 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/essentialkaos/ek/v12/req"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// CompositeExpr is composite metric expression
+type CompositeExpr string
+
+// CompositeOptions contains options of composite function (e.g. function
+// and period of series)
+type CompositeOptions map[string]string
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type compositeQueryResponse struct {
+	Resolution   int                `json:"resolution"`
+	Measurements []*compositeSeries `json:"measurements"`
+	Query        queryPageInfo      `json:"query"`
+}
+
+type compositeSeries struct {
+	Series []Point `json:"series"`
+	Query  struct {
+		Metric string `json:"metric"`
+		Source string `json:"source"`
+		Tags   Tags   `json:"tags"`
+	} `json:"query"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// QueryComposite synchronously fetches result of composite metric expression
+// from librato
+func QueryComposite(expr CompositeExpr, opts QueryOptions) (*QueryResult, []error) {
+	return defaultClient.QueryComposite(expr, opts)
+}
+
+// QueryCompositeContext synchronously fetches result of composite metric
+// expression from librato with given context
+func QueryCompositeContext(ctx context.Context, expr CompositeExpr, opts QueryOptions) (*QueryResult, []error) {
+	return defaultClient.QueryCompositeContext(ctx, expr, opts)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// QueryComposite synchronously fetches result of composite metric expression
+// from librato
+func (c *Client) QueryComposite(expr CompositeExpr, opts QueryOptions) (*QueryResult, []error) {
+	return c.QueryCompositeContext(context.Background(), expr, opts)
+}
+
+// QueryCompositeContext synchronously fetches result of composite metric
+// expression from librato with given context
+func (c *Client) QueryCompositeContext(ctx context.Context, expr CompositeExpr, opts QueryOptions) (*QueryResult, []error) {
+	err := validateCompositeQuery(expr, opts)
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	query := opts.toQuery()
+	query.Set("compose", string(expr))
+
+	resp := &compositeQueryResponse{}
+	errs := c.execJSONRequest(ctx, req.GET, "/v1/measurements", query, nil, resp)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	result := &QueryResult{
+		Resolution: resp.Resolution,
+		NextTime:   resp.Query.NextTime,
+	}
+
+	for _, m := range resp.Measurements {
+		result.Series = append(result.Series, &Series{
+			Metric: m.Query.Metric,
+			Source: m.Query.Source,
+			Tags:   m.Query.Tags,
+			Points: m.Series,
+		})
+	}
+
+	return result, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// S returns expression which selects series of metric with given source
+// (wildcards are supported)
+func S(metric, source string, opts ...CompositeOptions) CompositeExpr {
+	return compositeFunc("s", append(
+		[]string{quoteComposite(metric), quoteComposite(source)},
+		formatCompositeOptions(opts)...,
+	)...)
+}
+
+// STags returns expression which selects series of tagged metric with given
+// tags (wildcards are supported)
+func STags(metric string, tags Tags, opts ...CompositeOptions) CompositeExpr {
+	return compositeFunc("s", append(
+		[]string{quoteComposite(metric), formatCompositeTags(tags)},
+		formatCompositeOptions(opts)...,
+	)...)
+}
+
+// Sum returns expression which sums given series
+func Sum(series ...CompositeExpr) CompositeExpr {
+	return compositeFunc("sum", formatCompositeSet(series))
+}
+
+// Divide returns expression which divides first series by second
+func Divide(dividend, divisor CompositeExpr) CompositeExpr {
+	return compositeFunc("divide", formatCompositeSet([]CompositeExpr{dividend, divisor}))
+}
+
+// Derive returns expression which calculates derivative of series
+func Derive(series CompositeExpr, detectReset bool) CompositeExpr {
+	if !detectReset {
+		return compositeFunc("derive", string(series))
+	}
+
+	return compositeFunc("derive", string(series), `{detect_reset:"true"}`)
+}
+
+// Scale returns expression which multiplies series by given factor
+func Scale(series CompositeExpr, factor float64) CompositeExpr {
+	return compositeFunc(
+		"scale", string(series),
+		`{factor:`+quoteComposite(strconv.FormatFloat(factor, 'f', -1, 64))+`}`,
+	)
+}
+
+// Timeshift returns expression which shifts series back by given offset
+// (e.g. "1h", "1d", "1w")
+func Timeshift(offset string, series CompositeExpr) CompositeExpr {
+	return compositeFunc("timeshift", quoteComposite(offset), string(series))
+}
+
+// String returns expression as a string
+func (e CompositeExpr) String() string {
+	return string(e)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// compositeFunc returns expression with call of function with given arguments
+func compositeFunc(name string, args ...string) CompositeExpr {
+	return CompositeExpr(name + "(" + strings.Join(args, ", ") + ")")
+}
+
+// formatCompositeSet returns single series as is or set of series in brackets
+func formatCompositeSet(series []CompositeExpr) string {
+	if len(series) == 1 {
+		return string(series[0])
+	}
+
+	items := make([]string, 0, len(series))
+
+	for _, s := range series {
+		items = append(items, string(s))
+	}
+
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+// formatCompositeTags returns tags filter of series
+func formatCompositeTags(tags Tags) string {
+	names := make([]string, 0, len(tags))
+
+	for name := range tags {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	items := make([]string, 0, len(names))
+
+	for _, name := range names {
+		items = append(items, quoteComposite(name)+":"+quoteComposite(tags[name]))
+	}
+
+	return "{" + strings.Join(items, ", ") + "}"
+}
+
+// formatCompositeOptions returns list with formatted options of series
+func formatCompositeOptions(opts []CompositeOptions) []string {
+	var result []string
+
+	for _, o := range opts {
+		if len(o) == 0 {
+			continue
+		}
+
+		names := make([]string, 0, len(o))
+
+		for name := range o {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		items := make([]string, 0, len(names))
+
+		for _, name := range names {
+			items = append(items, name+":"+quoteComposite(o[name]))
+		}
+
+		result = append(result, "{"+strings.Join(items, ", ")+"}")
+	}
+
+	return result
+}
+
+// quoteComposite returns quoted string for composite expression
+func quoteComposite(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+
+	return `"` + s + `"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// validateCompositeQuery validates composite expression and query options
+func validateCompositeQuery(expr CompositeExpr, opts QueryOptions) error {
+	if strings.TrimSpace(string(expr)) == "" {
+		return errors.New("Composite expression can't be empty")
+	}
+
+	if opts.isSourceBased() || len(opts.Tags) != 0 || opts.TagsSearch != "" || opts.GroupBy != "" {
+		return errors.New("Sources, tags and grouping must be defined in composite expression")
+	}
+
+	return validateQueryOptions(opts)
+}
@@ -0,0 +1,92 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+const (
+	// ERROR_CATEGORY_PARAMS is category of errors related to request parameters
+	ERROR_CATEGORY_PARAMS = "params"
+
+	// ERROR_CATEGORY_REQUEST is category of errors related to request
+	ERROR_CATEGORY_REQUEST = "request"
+
+	// ERROR_CATEGORY_SYSTEM is category of API internal errors
+	ERROR_CATEGORY_SYSTEM = "system"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// APIError contains info about error returned by Librato API
+type APIError struct {
+	StatusCode int    // HTTP status code
+	Category   string // Error category (params/request/system)
+	Param      string // Name of parameter (only for params errors)
+	Message    string // Error message
+	URL        string // Request URL
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var (
+	// ErrUnauthorized is returned if access credentials are invalid
+	ErrUnauthorized = errors.New("Unauthorized")
+
+	// ErrForbidden is returned if access to resource is forbidden
+	ErrForbidden = errors.New("Forbidden")
+
+	// ErrNotFound is returned if requested resource doesn't exist
+	ErrNotFound = errors.New("Not found")
+
+	// ErrRateLimited is returned if request was rejected due to rate limit
+	ErrRateLimited = errors.New("Rate limit exceeded")
+
+	// ErrServerError is returned if API can't process request due to internal error
+	ErrServerError = errors.New("Server error")
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Error returns error message
+func (e *APIError) Error() string {
+	message := e.Message
+
+	if message == "" {
+		message = http.StatusText(e.StatusCode)
+	}
+
+	if e.Param != "" {
+		return fmt.Sprintf("%s: %s", e.Param, message)
+	}
+
+	return message
+}
+
+// Is returns true if error matches given sentinel error
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServerError:
+		return e.StatusCode >= 500
+	}
+
+	return false
+}
@@ -16,14 +16,14 @@ func main() {
 	librato.Token = "abcd1234abcd1234abcd1234abcd1234abcd1234abcd1234abcd1234abcd1234"
 
 	// Add annotation to example:annotation_1 stream
-	errs := librato.AddAnnotation("example:annotation_1",
+	event, errs := librato.CreateAnnotationEvent("example:annotation_1",
 		librato.Annotation{
 			Title:  "Deploy v31",
 			Source: "server123",
 			Desc:   "Revision: abcd1234",
-			Links: []string{
-				"https://build-service.com/build/31",
-				"https://git-repo.com/commit/abcd1234",
+			AnnotationLinks: []librato.AnnotationLink{
+				{Rel: "ci", Label: "Build #31", Href: "https://build-service.com/build/31"},
+				{Rel: "git", Label: "abcd1234", Href: "https://git-repo.com/commit/abcd1234"},
 			},
 		},
 	)
@@ -35,7 +35,23 @@ func main() {
 			fmt.Printf("  %v\n", err)
 		}
 	} else {
-		fmt.Println("Annotation added")
+		fmt.Printf("Annotation added (ID: %d)\n", event.ID)
+
+		time.Sleep(time.Minute)
+
+		// Set end time of the event to show it as a region
+		event.EndTime = time.Now().Unix()
+		errs = librato.UpdateAnnotationEvent("example:annotation_1", event.ID, event.Annotation)
+
+		if len(errs) != 0 {
+			fmt.Println("Errors:")
+
+			for _, err := range errs {
+				fmt.Printf("  %v\n", err)
+			}
+		} else {
+			fmt.Println("Annotation updated")
+		}
 	}
 
 	time.Sleep(time.Minute)
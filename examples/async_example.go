@@ -3,8 +3,12 @@ package main
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/essentialkaos/librato/v10"
@@ -26,6 +30,8 @@ func main() {
 		return
 	}
 
+	go shutdownHandler()
+
 	for {
 		metrics.Add(
 			librato.Gauge{
@@ -38,6 +44,28 @@ func main() {
 	}
 }
 
+// shutdownHandler sends all pending data to Librato on SIGTERM
+func shutdownHandler() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	<-signals
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	errs := librato.Shutdown(ctx)
+	cancel()
+
+	if len(errs) != 0 {
+		fmt.Println("Errors:")
+
+		for _, err := range errs {
+			fmt.Printf("  %v\n", err)
+		}
+	}
+
+	os.Exit(0)
+}
+
 func randomInt(n int) int {
 	rand.Seed(time.Now().UTC().UnixNano())
 	return rand.Intn(n)
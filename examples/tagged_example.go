@@ -0,0 +1,63 @@
+package main
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/essentialkaos/librato/v10"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func main() {
+	librato.Mail = "mail@domain.com"
+	librato.Token = "abcd1234abcd1234abcd1234abcd1234abcd1234abcd1234abcd1234abcd1234"
+
+	// Send tagged measurements with top-level tags
+	errs := librato.AddMeasurements(
+		librato.Tags{"region": "us-east-1"},
+		librato.TaggedMeasurement{
+			Name:  "example:tagged_1",
+			Value: randomInt(1000),
+			Tags:  librato.Tags{"host": "server123"},
+		},
+	)
+
+	if len(errs) != 0 {
+		fmt.Println("Errors:")
+
+		for _, err := range errs {
+			fmt.Printf("  %v\n", err)
+		}
+	}
+
+	// Create struct for async sending metrics data with top-level tags
+	metrics, err := librato.NewTaggedMetrics(
+		time.Minute, 60, librato.Tags{"region": "us-east-1"},
+	)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	for {
+		metrics.Add(
+			librato.TaggedMeasurement{
+				Name:  "example:tagged_2",
+				Value: randomInt(1000),
+				Tags:  librato.Tags{"host": "server123"},
+			},
+		)
+
+		time.Sleep(15 * time.Second)
+	}
+}
+
+func randomInt(n int) int {
+	rand.Seed(time.Now().UTC().UnixNano())
+	return rand.Intn(n)
+}
@@ -2,4 +2,13 @@ module github.com/essentialkaos/librato/v10
 
 go 1.17
 
-require github.com/essentialkaos/ek/v12 v12.43.0
+require (
+	github.com/essentialkaos/check v1.2.1
+	github.com/essentialkaos/ek/v12 v12.43.0
+)
+
+require (
+	github.com/kr/pretty v0.3.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/rogpeppe/go-internal v1.6.1 // indirect
+)
@@ -0,0 +1,311 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// HISTOGRAM_ACCURACY is relative accuracy of quantiles calculated by histogram
+const HISTOGRAM_ACCURACY = 0.01
+
+// HISTOGRAM_MAX_BUCKETS is maximum number of buckets used by histogram for
+// positive and negative samples. If limit is reached, the lowest buckets are
+// collapsed, so quantiles of the smallest samples lose accuracy, but memory
+// usage stays bounded.
+const HISTOGRAM_MAX_BUCKETS = 2048
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Histogram calculates quantiles of samples using streaming sketch and reports
+// them as gauges (name.p50, name.p95, name.p99) with summary multi-sample gauge.
+// Histogram is not a Measurement and can't be added to Metrics directly; use it
+// as Registry handle or add gauges returned by Snapshot.
+type Histogram struct {
+	name        string
+	source      string
+	percentiles []float64
+	sketch      *sketch
+	summary     *aggregate
+	dropped     uint64
+	mu          sync.Mutex
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// sketch is log-bucketed quantile sketch with relative accuracy guarantees
+type sketch struct {
+	gamma    float64
+	logGamma float64
+	positive *buckets
+	negative *buckets
+	zeros    uint64
+	count    uint64
+}
+
+// buckets is set of sketch buckets with limited size
+type buckets struct {
+	counts    map[int]uint64
+	floor     int // Samples with lower index are counted in floor bucket
+	collapsed bool
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// DefaultPercentiles is list of percentiles reported by histogram by default
+var DefaultPercentiles = []float64{50, 95, 99}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewHistogram creates new histogram with given name, source (can be empty)
+// and list of reported percentiles. Percentiles outside of (0, 100] range are
+// ignored, if there are no valid percentiles, default percentiles are used.
+func NewHistogram(name, source string, percentiles ...float64) *Histogram {
+	var validPercentiles []float64
+
+	for _, p := range percentiles {
+		if p > 0 && p <= 100 {
+			validPercentiles = append(validPercentiles, p)
+		}
+	}
+
+	if len(validPercentiles) == 0 {
+		validPercentiles = DefaultPercentiles
+	}
+
+	return &Histogram{
+		name:        name,
+		source:      source,
+		percentiles: validPercentiles,
+		sketch:      newSketch(HISTOGRAM_ACCURACY),
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Update adds sample to histogram. Non-finite samples (NaN and ±Inf) are
+// dropped.
+func (h *Histogram) Update(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !isFinite(v) {
+		h.dropped++
+		return
+	}
+
+	h.sketch.add(v)
+
+	if h.summary == nil {
+		h.summary = &aggregate{name: h.name, source: h.source}
+	}
+
+	h.summary.addSample(v)
+}
+
+// UpdateDuration adds duration sample (in milliseconds) to histogram
+func (h *Histogram) UpdateDuration(d time.Duration) {
+	h.Update(float64(d) / float64(time.Millisecond))
+}
+
+// Count returns number of samples added to histogram since the last snapshot
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.sketch.count
+}
+
+// Dropped returns number of non-finite samples dropped by histogram since
+// its creation
+func (h *Histogram) Dropped() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.dropped
+}
+
+// Quantile returns approximate value of given quantile (0-1) for samples
+// added since the last snapshot
+func (h *Histogram) Quantile(q float64) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.sketch.quantile(q)
+}
+
+// Snapshot returns summary gauge and percentile gauges for samples added since
+// the last snapshot and resets histogram
+func (h *Histogram) Snapshot() []Measurement {
+	h.mu.Lock()
+
+	sk, summary := h.sketch, h.summary
+	h.sketch, h.summary = newSketch(HISTOGRAM_ACCURACY), nil
+
+	h.mu.Unlock()
+
+	if summary == nil {
+		return nil
+	}
+
+	result := []Measurement{summary.toMeasurement(0)}
+
+	for _, p := range h.percentiles {
+		result = append(result, Gauge{
+			Name:   h.name + ".p" + strconv.FormatFloat(p, 'f', -1, 64),
+			Source: h.source,
+			Value:  sk.quantile(p / 100),
+		})
+	}
+
+	return result
+}
+
+// snapshot returns histogram gauges for registry
+func (h *Histogram) snapshot() []Measurement {
+	return h.Snapshot()
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// newSketch creates new sketch with given relative accuracy
+func newSketch(accuracy float64) *sketch {
+	gamma := (1 + accuracy) / (1 - accuracy)
+
+	return &sketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		positive: newBuckets(),
+		negative: newBuckets(),
+	}
+}
+
+// add adds value to sketch
+func (s *sketch) add(v float64) {
+	s.count++
+
+	switch {
+	case v > 0:
+		s.positive.add(s.index(v))
+	case v < 0:
+		s.negative.add(s.index(-v))
+	default:
+		s.zeros++
+	}
+}
+
+// quantile returns approximate value of given quantile
+func (s *sketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+
+	q = math.Max(0, math.Min(1, q))
+	rank := uint64(q * float64(s.count-1))
+
+	var seen uint64
+
+	for _, index := range sortedIndexes(s.negative.counts, true) {
+		seen += s.negative.counts[index]
+
+		if seen > rank {
+			return -s.value(index)
+		}
+	}
+
+	seen += s.zeros
+
+	if seen > rank {
+		return 0
+	}
+
+	for _, index := range sortedIndexes(s.positive.counts, false) {
+		seen += s.positive.counts[index]
+
+		if seen > rank {
+			return s.value(index)
+		}
+	}
+
+	return 0
+}
+
+// index returns bucket index for given positive value
+func (s *sketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value returns representative value of bucket with given index
+func (s *sketch) value(index int) float64 {
+	return 2 * math.Pow(s.gamma, float64(index)) / (s.gamma + 1)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// newBuckets creates new empty set of buckets
+func newBuckets() *buckets {
+	return &buckets{counts: make(map[int]uint64)}
+}
+
+// add increments counter of bucket with given index
+func (b *buckets) add(index int) {
+	if b.collapsed && index < b.floor {
+		index = b.floor
+	}
+
+	b.counts[index]++
+
+	if len(b.counts) > HISTOGRAM_MAX_BUCKETS {
+		b.collapse()
+	}
+}
+
+// collapse merges the lowest bucket into the next one to keep number of
+// buckets within limit
+func (b *buckets) collapse() {
+	lowest, next := math.MaxInt, math.MaxInt
+
+	for index := range b.counts {
+		switch {
+		case index < lowest:
+			lowest, next = index, lowest
+		case index < next:
+			next = index
+		}
+	}
+
+	b.counts[next] += b.counts[lowest]
+	b.floor, b.collapsed = next, true
+
+	delete(b.counts, lowest)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// sortedIndexes returns sorted bucket indexes
+func sortedIndexes(buckets map[int]uint64, reverse bool) []int {
+	result := make([]int, 0, len(buckets))
+
+	for index := range buckets {
+		result = append(result, index)
+	}
+
+	if reverse {
+		sort.Sort(sort.Reverse(sort.IntSlice(result)))
+	} else {
+		sort.Ints(result)
+	}
+
+	return result
+}
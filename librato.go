@@ -9,10 +9,18 @@ package librato
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/essentialkaos/ek/v12/req"
@@ -26,6 +34,19 @@ const VERSION = "10.0.2"
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
+const (
+	// DROP_OLDEST drops the oldest measurements on buffer overflow
+	DROP_OLDEST DropPolicy = iota
+
+	// DROP_NEWEST drops the newest measurements on buffer overflow
+	DROP_NEWEST
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// DropPolicy is policy of dropping measurements on buffer overflow
+type DropPolicy uint8
+
 // Measurement is interface for different type of measurements
 type Measurement interface {
 	Validate() error
@@ -34,6 +55,9 @@ type Measurement interface {
 // DataSource is interface for diferent type of data source
 type DataSource interface {
 	Send() []error
+	SendContext(ctx context.Context) []error
+	Flush(ctx context.Context) []error
+	Close()
 
 	getPeriod() time.Duration
 	getLastSendingDate() int64
@@ -42,28 +66,116 @@ type DataSource interface {
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
+// Client is Librato API client
+type Client struct {
+	// Access credentials
+	Mail  string
+	Token string
+
+	// APIEndpoint contains URL of Librato API endpoint
+	APIEndpoint string
+
+	// UserAgent contains custom User-Agent used for all requests (it's set for
+	// every request, so engine can be shared between clients)
+	UserAgent string
+
+	// Engine is req.Engine which used for sync requests (if not set, client
+	// creates its own engine)
+	Engine *req.Engine
+
+	// RetryPolicy is policy used for retrying failed requests
+	RetryPolicy *RetryPolicy
+
+	// MaxBatchSize is maximum number of measurements sent by one request
+	// (DEFAULT_MAX_BATCH_SIZE by default)
+	MaxBatchSize int
+
+	// MaxPayloadSize is maximum size of request body in bytes
+	// (DEFAULT_MAX_PAYLOAD_SIZE by default)
+	MaxPayloadSize int
+
+	// MaxParallelRequests is maximum number of batches sent in parallel
+	// (batches are sent sequentially by default)
+	MaxParallelRequests int
+
+	// RateLimitHandler is function executed every time when API returns info
+	// about rate limits. Handler is executed synchronously by the goroutine
+	// which made the request (batches may be sent in parallel), so it must be
+	// fast and safe for concurrent use.
+	RateLimitHandler func(limits RateLimits)
+
+	rateLimits   RateLimits
+	rateLimitsMu sync.RWMutex
+
+	engine     *req.Engine // Default engine used if Engine is not set
+	engineOnce sync.Once
+	engineMu   sync.Mutex
+
+	global bool
+}
+
 // Metrics struct
 type Metrics struct {
+	lastSendingDate int64 // must be first for 64-bit alignment of atomic operations
+	requeued        uint64
+	dropped         uint64
+	added           int
+	client          *Client
 	period          time.Duration
 	maxQueueSize    int
-	lastSendingDate int64
 	initialized     bool
 	queue           []Measurement
+	queueMu         sync.Mutex
+	sending         int
+	sendingDone     chan struct{}
+	sendingMu       sync.Mutex
 
 	// Function executed if we have errors while sending data to Librato
 	ErrorHandler func(errs []error)
 	Engine       *req.Engine
+
+	// Top-level tags added to all tagged measurements
+	Tags Tags
+
+	// RetryPolicy is policy used for retrying failed sendings (if not set,
+	// client retry policy will be used)
+	RetryPolicy *RetryPolicy
+
+	// MaxBufferSize is maximum number of measurements stored in the queue. If
+	// set, measurements which weren't sent due to temporary failure will be
+	// put back to the queue.
+	MaxBufferSize int
+
+	// DropPolicy defines which measurements will be dropped if the queue
+	// size is greater than MaxBufferSize
+	DropPolicy DropPolicy
+}
+
+// MetricsStats contains statistics of async metrics sending
+type MetricsStats struct {
+	Requeued uint64 // Number of measurements put back to the queue after failure
+	Dropped  uint64 // Number of measurements dropped due to buffer overflow, disabled requeue or permanent failure
 }
 
-// Collector struct
+// Collector struct. Collected measurements which weren't sent (after all
+// retries) are not requeued, because collect function provides fresh values
+// every period. Errors are passed to ErrorHandler.
 type Collector struct {
+	lastSendingDate int64 // must be first for 64-bit alignment of atomic operations
+	client          *Client
 	period          time.Duration
-	lastSendingDate int64
 	collectFunc     func() []Measurement
 
 	// Function executed if we have errors while sending data to Librato
 	ErrorHandler func(errs []error)
 	Engine       *req.Engine
+
+	// Top-level tags added to all tagged measurements
+	Tags Tags
+
+	// RetryPolicy is policy used for retrying failed sendings (if not set,
+	// client retry policy will be used)
+	RetryPolicy *RetryPolicy
 }
 
 // Gauge struct
@@ -78,7 +190,7 @@ type Gauge struct {
 
 	// The numeric value of an individual measurement. Multiple formats are
 	// supported (e.g. integer, floating point, etc) but the value must be numeric.
-	Value interface{} `json:"value"`
+	Value interface{} `json:"value,omitempty"`
 
 	// The epoch time at which an individual measurement occurred with a maximum
 	// resolution of seconds.
@@ -174,7 +286,13 @@ type Annotation struct {
 	// annotation. For example, these links could point to a build page in a CI
 	// system or a changeset description of an SCM. Each link has a tag that
 	// defines the link\'s relationship to the annotation.
-	Links []string `json:"links,omitempty"`
+	AnnotationLinks []AnnotationLink `json:"-"`
+
+	// List of URLs of resources associated with the annotation. Every URL is sent
+	// as link with default rel (DEFAULT_LINK_REL).
+	//
+	// Deprecated: Use AnnotationLinks instead
+	Links []string `json:"-"`
 
 	// The unix timestamp indicating the the time at which the event referenced by this
 	// annotation started. By default this is set to the current time if not specified.
@@ -219,13 +337,14 @@ type systemErrors struct {
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
-// Access credentials
+// Access credentials used by package-level methods
 var (
 	Mail  = ""
 	Token = ""
 )
 
-// APIEndpoint contians URL of Librato API endpoint
+// APIEndpoint contians URL of Librato API endpoint used by package-level methods
+// and by clients without custom endpoint
 var APIEndpoint = "https://metrics-api.librato.com"
 
 // Engine is global req.Engine which used for sync requests
@@ -234,32 +353,148 @@ var Engine = &req.Engine{}
 // UseGlobalEngine set to true for using global engine for all requests
 var UseGlobalEngine = false
 
+// SendingTimeout is maximum duration of every sending made by background
+// sending loop
+var SendingTimeout = 30 * time.Second
+
+// RateLimitHandler is function executed every time when API returns info
+// about rate limits in response to package-level methods
+var RateLimitHandler func(limits RateLimits)
+
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 // List of sources
 var sources []DataSource
 
+// sourcesMu is sources list mutex
+var sourcesMu sync.Mutex
+
+// loopStop is channel used for stopping sending loop
+var loopStop chan struct{}
+
+// defaultClient is client used by package-level methods
+var defaultClient = &Client{global: true}
+
 // Some default errors
 var (
 	errAccessCredentials = []error{errors.New("Access credentials is not set")}
 	errEmptyStreamName   = []error{errors.New("Stream name can't be empty")}
+	errEmptyMetricName   = []error{errors.New("Metric name can't be empty")}
+	errEmptySourceName   = []error{errors.New("Source name can't be empty")}
+	errInvalidEventID    = []error{errors.New("Annotation event ID must be greater than zero")}
+	errInvalidAlertID    = []error{errors.New("Alert ID must be greater than zero")}
+	errInvalidServiceID  = []error{errors.New("Service ID must be greater than zero")}
+	errInvalidSpaceID    = []error{errors.New("Space ID must be greater than zero")}
+	errInvalidChartID    = []error{errors.New("Chart ID must be greater than zero")}
+	errInvalidSnapshotID = []error{errors.New("Snapshot ID must be greater than zero")}
 	errEngineIsNil       = []error{errors.New("Engine is nil")}
 )
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
+// NewClient creates new Librato API client
+func NewClient(mail, token string) *Client {
+	return &Client{
+		Mail:        mail,
+		Token:       token,
+		APIEndpoint: APIEndpoint,
+		Engine:      &req.Engine{},
+	}
+}
+
 // NewMetrics create new metrics struct for async metrics sending
 func NewMetrics(period time.Duration, maxQueueSize int) (*Metrics, error) {
+	return defaultClient.NewMetrics(period, maxQueueSize)
+}
+
+// NewTaggedMetrics create new metrics struct for async metrics sending with
+// top-level tags added to all tagged measurements
+func NewTaggedMetrics(period time.Duration, maxQueueSize int, tags Tags) (*Metrics, error) {
+	return defaultClient.NewTaggedMetrics(period, maxQueueSize, tags)
+}
+
+// NewCollector create new metrics struct for async metrics collecting and sending
+func NewCollector(period time.Duration, collectFunc func() []Measurement) *Collector {
+	return defaultClient.NewCollector(period, collectFunc)
+}
+
+// AddMetric synchronously send metric to librato
+func AddMetric(m ...Measurement) []error {
+	return defaultClient.AddMetric(m...)
+}
+
+// AddMetricContext synchronously send metric to librato with given context
+func AddMetricContext(ctx context.Context, m ...Measurement) []error {
+	return defaultClient.AddMetricContext(ctx, m...)
+}
+
+// AddAnnotation synchronously send annotation to librato
+func AddAnnotation(stream string, a Annotation) []error {
+	return defaultClient.AddAnnotation(stream, a)
+}
+
+// AddAnnotationContext synchronously send annotation to librato with given context
+func AddAnnotationContext(ctx context.Context, stream string, a Annotation) []error {
+	return defaultClient.AddAnnotationContext(ctx, stream, a)
+}
+
+// DeleteAnnotations synchronously remove annotation stream on librato
+func DeleteAnnotations(stream string) []error {
+	return defaultClient.DeleteAnnotations(stream)
+}
+
+// DeleteAnnotationsContext synchronously remove annotation stream on librato
+// with given context
+func DeleteAnnotationsContext(ctx context.Context, stream string) []error {
+	return defaultClient.DeleteAnnotationsContext(ctx, stream)
+}
+
+// Shutdown stops background sending loop, flushes and unregisters all
+// registered data sources
+func Shutdown(ctx context.Context) []error {
+	sourcesMu.Lock()
+
+	if loopStop != nil {
+		close(loopStop)
+		loopStop = nil
+	}
+
+	list := sources
+	sources = nil
+
+	sourcesMu.Unlock()
+
+	var errs []error
+
+	for _, source := range list {
+		errs = append(errs, source.Flush(ctx)...)
+	}
+
+	return errs
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewMetrics create new metrics struct for async metrics sending
+func (c *Client) NewMetrics(period time.Duration, maxQueueSize int) (*Metrics, error) {
+	return c.NewTaggedMetrics(period, maxQueueSize, nil)
+}
+
+// NewTaggedMetrics create new metrics struct for async metrics sending with
+// top-level tags added to all tagged measurements
+func (c *Client) NewTaggedMetrics(period time.Duration, maxQueueSize int, tags Tags) (*Metrics, error) {
 	metrics := &Metrics{
+		client:          c,
 		maxQueueSize:    maxQueueSize,
 		period:          period,
 		initialized:     true,
 		queue:           make([]Measurement, 0),
 		lastSendingDate: -1,
+		Tags:            tags,
 	}
 
 	if UseGlobalEngine {
-		metrics.Engine = Engine
+		metrics.Engine = c.getEngine()
 	} else {
 		metrics.Engine = &req.Engine{}
 	}
@@ -270,44 +505,38 @@ func NewMetrics(period time.Duration, maxQueueSize int) (*Metrics, error) {
 		return nil, err
 	}
 
-	if sources == nil {
-		sources = make([]DataSource, 0)
-		go sendingLoop()
-	}
-
-	sources = append(sources, metrics)
+	registerSource(metrics)
 
 	return metrics, nil
 }
 
 // NewCollector create new metrics struct for async metrics collecting and sending
-func NewCollector(period time.Duration, collectFunc func() []Measurement) *Collector {
+func (c *Client) NewCollector(period time.Duration, collectFunc func() []Measurement) *Collector {
 	collector := &Collector{
+		client:          c,
 		period:          period,
 		collectFunc:     collectFunc,
 		lastSendingDate: -1,
 	}
 
-	if sources == nil {
-		sources = make([]DataSource, 0)
-		go sendingLoop()
-	}
-
 	if UseGlobalEngine {
-		collector.Engine = Engine
+		collector.Engine = c.getEngine()
 	} else {
 		collector.Engine = &req.Engine{}
 	}
 
-	sources = append(sources, collector)
+	registerSource(collector)
 
 	return collector
 }
 
 // AddMetric synchronously send metric to librato
-func AddMetric(m ...Measurement) []error {
-	data := measurements{}
+func (c *Client) AddMetric(m ...Measurement) []error {
+	return c.AddMetricContext(context.Background(), m...)
+}
 
+// AddMetricContext synchronously send metric to librato with given context
+func (c *Client) AddMetricContext(ctx context.Context, m ...Measurement) []error {
 	var errs []error
 
 	for _, metric := range m {
@@ -322,46 +551,44 @@ func AddMetric(m ...Measurement) []error {
 		return errs
 	}
 
-	for _, metric := range m {
-		switch metric.(type) {
-		case Gauge:
-			data.Gauges = append(data.Gauges, metric.(Gauge))
+	errs, _, _ = c.sendMeasurements(ctx, m, sendOptions{
+		engine: c.getEngine(),
+		policy: c.getRetryPolicy(),
+	})
 
-		case Counter:
-			data.Counters = append(data.Counters, metric.(Counter))
-		}
-	}
-
-	return execRequest(Engine, req.POST, APIEndpoint+"/v1/metrics/", data)
+	return errs
 }
 
 // AddAnnotation synchronously send annotation to librato
-func AddAnnotation(stream string, a Annotation) []error {
-	if stream == "" {
-		return errAccessCredentials
-	}
-
-	err := validateAnotation(a)
-
-	if err != nil {
-		return []error{err}
-	}
+func (c *Client) AddAnnotation(stream string, a Annotation) []error {
+	return c.AddAnnotationContext(context.Background(), stream, a)
+}
 
-	return execRequest(Engine, req.POST, APIEndpoint+"/v1/annotations/"+stream, a)
+// AddAnnotationContext synchronously send annotation to librato with given context
+func (c *Client) AddAnnotationContext(ctx context.Context, stream string, a Annotation) []error {
+	_, errs := c.CreateAnnotationEventContext(ctx, stream, a)
+	return errs
 }
 
 // DeleteAnnotations synchronously remove annotation stream on librato
-func DeleteAnnotations(stream string) []error {
+func (c *Client) DeleteAnnotations(stream string) []error {
+	return c.DeleteAnnotationsContext(context.Background(), stream)
+}
+
+// DeleteAnnotationsContext synchronously remove annotation stream on librato
+// with given context
+func (c *Client) DeleteAnnotationsContext(ctx context.Context, stream string) []error {
 	if stream == "" {
 		return errEmptyStreamName
 	}
 
-	return execRequest(Engine, req.DELETE, APIEndpoint+"/v1/annotations/"+stream, nil)
+	return c.execRequest(ctx, c.getEngine(), req.DELETE, getAnnotationPath(stream), nil, nil)
 }
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
-// Add adds gauge to sending queue
+// Add adds measurements to sending queue. If queue is full, it will be sent by
+// background sending loop as soon as possible.
 func (mt *Metrics) Add(m ...Measurement) error {
 	var err error
 
@@ -377,12 +604,23 @@ func (mt *Metrics) Add(m ...Measurement) error {
 		if err != nil {
 			return err
 		}
+
+		err = validateMeasurementTags(metric, mt.Tags)
+
+		if err != nil {
+			return err
+		}
 	}
 
+	mt.queueMu.Lock()
 	mt.queue = append(mt.queue, m...)
+	mt.added += len(m)
+	mt.trimQueue()
+	isFull := mt.added >= mt.maxQueueSize
+	mt.queueMu.Unlock()
 
-	if len(mt.queue) >= mt.maxQueueSize {
-		mt.Send()
+	if isFull {
+		mt.scheduleSending()
 	}
 
 	return nil
@@ -390,7 +628,12 @@ func (mt *Metrics) Add(m ...Measurement) error {
 
 // Send sends metrics data to Librato service
 func (mt *Metrics) Send() []error {
-	if Mail == "" || Token == "" {
+	return mt.SendContext(context.Background())
+}
+
+// SendContext sends metrics data to Librato service with given context
+func (mt *Metrics) SendContext(ctx context.Context) []error {
+	if !mt.client.hasCredentials() {
 		return errAccessCredentials
 	}
 
@@ -400,17 +643,31 @@ func (mt *Metrics) Send() []error {
 		return []error{err}
 	}
 
-	if len(mt.queue) == 0 {
+	mt.beginSending()
+	defer mt.endSending()
+
+	data := mt.takeQueue()
+
+	if len(data) == 0 {
 		return nil
 	}
 
-	mt.lastSendingDate = time.Now().Unix()
+	atomic.StoreInt64(&mt.lastSendingDate, time.Now().Unix())
 
-	data := convertMeasurementSlice(mt.queue)
+	errs, failed, rejected := mt.client.sendMeasurements(ctx, data, sendOptions{
+		engine:   mt.Engine,
+		policy:   mt.getRetryPolicy(),
+		tags:     mt.Tags,
+		throttle: true,
+	})
 
-	mt.queue = make([]Measurement, 0)
+	if rejected != 0 {
+		atomic.AddUint64(&mt.dropped, uint64(rejected))
+	}
 
-	errs := execRequest(mt.Engine, req.POST, APIEndpoint+"/v1/metrics/", data)
+	if len(failed) != 0 {
+		mt.requeue(failed)
+	}
 
 	mt.execErrorHandler(errs)
 
@@ -419,7 +676,12 @@ func (mt *Metrics) Send() []error {
 
 // Send sends metrics data to Librato service
 func (cl *Collector) Send() []error {
-	if Mail == "" || Token == "" {
+	return cl.SendContext(context.Background())
+}
+
+// SendContext sends metrics data to Librato service with given context
+func (cl *Collector) SendContext(ctx context.Context) []error {
+	if !cl.client.hasCredentials() {
 		return errAccessCredentials
 	}
 
@@ -431,8 +693,18 @@ func (cl *Collector) Send() []error {
 
 	var errs []error
 
+	err := validateTags(cl.Tags)
+
+	if err != nil {
+		errs = append(errs, err)
+	}
+
 	for _, m := range measurements {
-		err := m.Validate()
+		err = m.Validate()
+
+		if err == nil {
+			err = validateMeasurementTags(m, cl.Tags)
+		}
 
 		if err != nil {
 			errs = append(errs, err)
@@ -444,16 +716,60 @@ func (cl *Collector) Send() []error {
 		return errs
 	}
 
-	cl.lastSendingDate = time.Now().Unix()
+	atomic.StoreInt64(&cl.lastSendingDate, time.Now().Unix())
 
-	data := convertMeasurementSlice(measurements)
-	errs = execRequest(cl.Engine, req.POST, APIEndpoint+"/v1/metrics/", data)
+	errs, _, _ = cl.client.sendMeasurements(ctx, measurements, sendOptions{
+		engine:   cl.Engine,
+		policy:   cl.getRetryPolicy(),
+		tags:     cl.Tags,
+		throttle: true,
+	})
 
 	cl.execErrorHandler(errs)
 
 	return errs
 }
 
+// Flush synchronously sends all pending metrics data to Librato service
+func (mt *Metrics) Flush(ctx context.Context) []error {
+	var errs []error
+
+	if mt.getQueueSize() != 0 {
+		errs = mt.SendContext(ctx)
+	}
+
+	err := mt.waitSending(ctx)
+
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// Stats returns statistics of requeued and dropped measurements
+func (mt *Metrics) Stats() MetricsStats {
+	return MetricsStats{
+		Requeued: atomic.LoadUint64(&mt.requeued),
+		Dropped:  atomic.LoadUint64(&mt.dropped),
+	}
+}
+
+// Close removes metrics from the list of data sources processed by sending loop
+func (mt *Metrics) Close() {
+	unregisterSource(mt)
+}
+
+// Flush synchronously collects and sends metrics data to Librato service
+func (cl *Collector) Flush(ctx context.Context) []error {
+	return cl.SendContext(ctx)
+}
+
+// Close removes collector from the list of data sources processed by sending loop
+func (cl *Collector) Close() {
+	unregisterSource(cl)
+}
+
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 // Validate validates gauge struct
@@ -468,6 +784,70 @@ func (c Counter) Validate() error {
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
+// getMail returns account mail
+func (c *Client) getMail() string {
+	if c.global {
+		return Mail
+	}
+
+	return c.Mail
+}
+
+// getToken returns API token
+func (c *Client) getToken() string {
+	if c.global {
+		return Token
+	}
+
+	return c.Token
+}
+
+// getAPIEndpoint returns URL of API endpoint
+func (c *Client) getAPIEndpoint() string {
+	if c.global || c.APIEndpoint == "" {
+		return APIEndpoint
+	}
+
+	return c.APIEndpoint
+}
+
+// getEngine returns engine for sync requests
+func (c *Client) getEngine() *req.Engine {
+	switch {
+	case c.global:
+		return Engine
+	case c.Engine != nil:
+		return c.Engine
+	}
+
+	c.engineOnce.Do(func() { c.engine = &req.Engine{} })
+
+	return c.engine
+}
+
+// getRetryPolicy returns retry policy used for requests
+func (c *Client) getRetryPolicy() *RetryPolicy {
+	if c.global {
+		return DefaultRetryPolicy
+	}
+
+	return c.RetryPolicy
+}
+
+// getRateLimitHandler returns rate limits handler
+func (c *Client) getRateLimitHandler() func(limits RateLimits) {
+	if c.global {
+		return RateLimitHandler
+	}
+
+	return c.RateLimitHandler
+}
+
+// hasCredentials returns true if access credentials is set
+func (c *Client) hasCredentials() bool {
+	return c.getMail() != "" && c.getToken() != ""
+}
+
 // getPeriod return sending period
 func (mt *Metrics) getPeriod() time.Duration {
 	return mt.period
@@ -475,7 +855,122 @@ func (mt *Metrics) getPeriod() time.Duration {
 
 // getLastSendingDate return last sending date
 func (mt *Metrics) getLastSendingDate() int64 {
-	return mt.lastSendingDate
+	return atomic.LoadInt64(&mt.lastSendingDate)
+}
+
+// getRetryPolicy returns retry policy used for sending
+func (mt *Metrics) getRetryPolicy() *RetryPolicy {
+	if mt.RetryPolicy != nil {
+		return mt.RetryPolicy
+	}
+
+	return mt.client.getRetryPolicy()
+}
+
+// getQueueSize returns number of measurements in queue
+func (mt *Metrics) getQueueSize() int {
+	mt.queueMu.Lock()
+	defer mt.queueMu.Unlock()
+
+	return len(mt.queue)
+}
+
+// beginSending marks start of sending
+func (mt *Metrics) beginSending() {
+	mt.sendingMu.Lock()
+
+	if mt.sending == 0 {
+		mt.sendingDone = make(chan struct{})
+	}
+
+	mt.sending++
+
+	mt.sendingMu.Unlock()
+}
+
+// endSending marks end of sending and notifies waiters if there are no
+// in-flight sendings anymore
+func (mt *Metrics) endSending() {
+	mt.sendingMu.Lock()
+
+	mt.sending--
+
+	if mt.sending == 0 {
+		close(mt.sendingDone)
+	}
+
+	mt.sendingMu.Unlock()
+}
+
+// waitSending waits until all in-flight sendings are finished
+func (mt *Metrics) waitSending(ctx context.Context) error {
+	mt.sendingMu.Lock()
+	sending, done := mt.sending, mt.sendingDone
+	mt.sendingMu.Unlock()
+
+	if sending == 0 {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// scheduleSending asks sending loop to send queue on the next tick. Queue is
+// not sent synchronously, so Add never blocks on requests or rate limits.
+func (mt *Metrics) scheduleSending() {
+	atomic.StoreInt64(&mt.lastSendingDate, 0)
+}
+
+// takeQueue returns all measurements from queue and resets it
+func (mt *Metrics) takeQueue() []Measurement {
+	mt.queueMu.Lock()
+	defer mt.queueMu.Unlock()
+
+	data := mt.queue
+	mt.queue = make([]Measurement, 0, len(data))
+	mt.added = 0
+
+	return data
+}
+
+// requeue puts measurements which weren't sent back to the queue
+func (mt *Metrics) requeue(data []Measurement) {
+	if mt.MaxBufferSize <= 0 {
+		atomic.AddUint64(&mt.dropped, uint64(len(data)))
+		return
+	}
+
+	mt.queueMu.Lock()
+	defer mt.queueMu.Unlock()
+
+	mt.queue = append(append(make([]Measurement, 0, len(data)+len(mt.queue)), data...), mt.queue...)
+
+	atomic.AddUint64(&mt.requeued, uint64(len(data)))
+
+	mt.trimQueue()
+}
+
+// trimQueue removes measurements from the queue if its size is greater than
+// max buffer size
+func (mt *Metrics) trimQueue() {
+	if mt.MaxBufferSize <= 0 || len(mt.queue) <= mt.MaxBufferSize {
+		return
+	}
+
+	overflow := len(mt.queue) - mt.MaxBufferSize
+
+	atomic.AddUint64(&mt.dropped, uint64(overflow))
+
+	if mt.DropPolicy == DROP_NEWEST {
+		mt.queue = mt.queue[:mt.MaxBufferSize]
+	} else {
+		mt.queue = append(make([]Measurement, 0, mt.MaxBufferSize), mt.queue[overflow:]...)
+	}
 }
 
 // execErrorHandler exec error handler if present
@@ -494,7 +989,16 @@ func (cl *Collector) getPeriod() time.Duration {
 
 // getLastSendingDate return last sending date
 func (cl *Collector) getLastSendingDate() int64 {
-	return cl.lastSendingDate
+	return atomic.LoadInt64(&cl.lastSendingDate)
+}
+
+// getRetryPolicy returns retry policy used for sending
+func (cl *Collector) getRetryPolicy() *RetryPolicy {
+	if cl.RetryPolicy != nil {
+		return cl.RetryPolicy
+	}
+
+	return cl.client.getRetryPolicy()
 }
 
 // execErrorHandler exec error handler if present
@@ -508,33 +1012,122 @@ func (cl *Collector) execErrorHandler(errs []error) {
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
+// registerSource adds data source to sources list and starts sending loop
+func registerSource(source DataSource) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+
+	if loopStop == nil {
+		loopStop = make(chan struct{})
+		go sendingLoop(loopStop)
+	}
+
+	sources = append(sources, source)
+}
+
+// unregisterSource removes data source from sources list
+func unregisterSource(source DataSource) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+
+	for i, s := range sources {
+		if s == source {
+			sources = append(sources[:i], sources[i+1:]...)
+			return
+		}
+	}
+}
+
+// getSources returns copy of sources list
+func getSources() []DataSource {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+
+	return append([]DataSource(nil), sources...)
+}
+
 // sendingLoop is function used for async sending data from data sources
-func sendingLoop() {
+func sendingLoop(stop chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+
+	defer ticker.Stop()
+
 	for {
-		time.Sleep(time.Second)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		list := getSources()
 
-		if len(sources) == 0 {
+		if len(list) == 0 {
 			continue
 		}
 
 		now := time.Now().Unix()
 
-		for _, source := range sources {
+		for _, source := range list {
 			period := int64(timeutil.DurationToSeconds(source.getPeriod()))
 			lastSendTime := source.getLastSendingDate()
 
 			if period == 0 || lastSendTime <= 0 {
-				go source.Send()
+				go sendWithTimeout(source)
 				continue
 			}
 
 			if period+lastSendTime <= now {
-				go source.Send()
+				go sendWithTimeout(source)
 			}
 		}
 	}
 }
 
+// sendWithTimeout sends data from data source with sending timeout
+func sendWithTimeout(source DataSource) {
+	ctx, cancel := context.WithTimeout(context.Background(), SendingTimeout)
+	defer cancel()
+
+	source.SendContext(ctx)
+}
+
+// sendMeasurements sends source-based and tagged measurements to API and returns
+// errors, measurements which weren't sent due to temporary failure and number
+// of rejected measurements
+func (c *Client) sendMeasurements(ctx context.Context, data []Measurement, opts sendOptions) ([]error, []Measurement, int) {
+	data, errs := filterUntaggedMeasurements(data, opts.tags)
+
+	if len(data) == 0 {
+		return errs, nil, len(errs)
+	}
+
+	sendErrs, failed, rejected := c.sendBatches(ctx, c.createBatches(data, opts.tags), opts)
+
+	return append(errs, sendErrs...), failed, rejected + len(errs)
+}
+
+// splitMeasurements splits measurements to source-based and tagged
+func splitMeasurements(data []Measurement) ([]Measurement, []Measurement) {
+	var legacy, tagged []Measurement
+
+	for _, m := range data {
+		switch m.(type) {
+		case Gauge, Counter:
+			legacy = append(legacy, m)
+		case TaggedMeasurement:
+			tagged = append(tagged, m)
+		}
+	}
+
+	return legacy, tagged
+}
+
+// isTemporaryFailure returns true if request with given status code failed
+// due to temporary problems
+func isTemporaryFailure(statusCode int) bool {
+	return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
 // convertMeasurementSlice convert slice with measurements to struct
 // with counters and gauges slices
 func convertMeasurementSlice(data []Measurement) measurements {
@@ -561,45 +1154,150 @@ func convertMeasurementSlice(data []Measurement) measurements {
 	return result
 }
 
-// execRequest create and execute request to API
-func execRequest(engine *req.Engine, method, url string, data interface{}) []error {
+// extractTaggedMeasurements returns all tagged measurements from given slice
+func extractTaggedMeasurements(data []Measurement) []TaggedMeasurement {
+	var result []TaggedMeasurement
+
+	for _, m := range data {
+		tm, ok := m.(TaggedMeasurement)
+
+		if ok {
+			result = append(result, tm)
+		}
+	}
+
+	return result
+}
+
+// execRequest create and execute request to API and decode response to given
+// result struct (if set). Only idempotent requests are retried, because
+// repeating POST could create duplicate objects.
+func (c *Client) execRequest(ctx context.Context, engine *req.Engine, method, path string, data, result interface{}) []error {
+	var policy *RetryPolicy
+
+	if isIdempotentMethod(method) {
+		policy = c.getRetryPolicy()
+	}
+
+	_, errs := c.execRequestWithPolicy(ctx, engine, policy, method, path, data, result)
+
+	return errs
+}
+
+// execRequestWithPolicy create and execute request to API and retry it
+// using given retry policy. It returns status code of the last attempt.
+func (c *Client) execRequestWithPolicy(ctx context.Context, engine *req.Engine, policy *RetryPolicy, method, path string, data, result interface{}) (int, []error) {
+	for attempt := 1; ; attempt++ {
+		statusCode, header, errs := c.doRequest(ctx, engine, method, path, data, result)
+
+		if len(errs) == 0 || !policy.isRetryable(ctx, attempt, statusCode) {
+			return statusCode, errs
+		}
+
+		delay := policy.getDelay(attempt, parseRetryAfter(header))
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return statusCode, append(errs, ctx.Err())
+		}
+	}
+}
+
+// doRequest executes single request to API and returns response status code
+// and headers
+func (c *Client) doRequest(ctx context.Context, engine *req.Engine, method, path string, data, result interface{}) (int, http.Header, []error) {
 	if engine == nil {
-		return errEngineIsNil
+		return 0, nil, errEngineIsNil
+	}
+
+	userAgent := c.initEngine(engine)
+
+	if engine.Client == nil {
+		return 0, nil, []error{req.ErrClientIsNil}
+	}
+
+	request, err := c.createRequest(ctx, userAgent, method, path, data)
+
+	if err != nil {
+		return 0, nil, []error{err}
+	}
+
+	resp, err := engine.Client.Do(request)
+
+	if err != nil {
+		return 0, nil, []error{err}
+	}
+
+	defer resp.Body.Close()
+
+	c.updateRateLimits(resp.Header)
+
+	if resp.StatusCode > 299 || resp.StatusCode == 0 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header, extractErrors(resp.StatusCode, request.URL.String(), string(body))
 	}
 
+	if result != nil && resp.StatusCode != http.StatusNoContent {
+		err = json.NewDecoder(resp.Body).Decode(result)
+
+		if err != nil && err != io.EOF {
+			return resp.StatusCode, resp.Header, []error{fmt.Errorf("Can't decode response: %v", err)}
+		}
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return resp.StatusCode, resp.Header, nil
+}
+
+// initEngine initializes engine and returns User-Agent used for request
+func (c *Client) initEngine(engine *req.Engine) string {
+	c.engineMu.Lock()
+
 	if engine.UserAgent == "" {
 		engine.SetUserAgent("go-ek-librato", VERSION)
 	}
 
-	request := req.Request{
-		Method: method,
-		URL:    url,
+	engine.Init()
 
-		BasicAuthUsername: Mail,
-		BasicAuthPassword: Token,
+	userAgent := engine.UserAgent
 
-		ContentType: req.CONTENT_TYPE_JSON,
+	c.engineMu.Unlock()
 
-		Close: true,
+	if c.UserAgent != "" {
+		return c.UserAgent
 	}
 
+	return userAgent
+}
+
+// createRequest creates HTTP request to API bound to given context
+func (c *Client) createRequest(ctx context.Context, userAgent, method, path string, data interface{}) (*http.Request, error) {
+	var body io.Reader
+
 	if data != nil {
-		request.Body = data
-	}
+		payload, err := json.Marshal(data)
 
-	resp, err := engine.Do(request)
+		if err != nil {
+			return nil, fmt.Errorf("Can't encode request body: %v", err)
+		}
 
-	if err != nil {
-		return []error{err}
+		body = bytes.NewReader(payload)
 	}
 
-	if resp.StatusCode > 299 || resp.StatusCode == 0 {
-		return extractErrors(resp.String())
+	request, err := http.NewRequestWithContext(ctx, method, c.getAPIEndpoint()+path, body)
+
+	if err != nil {
+		return nil, err
 	}
 
-	resp.Discard()
+	request.Header.Set("Content-Type", req.CONTENT_TYPE_JSON)
+	request.Header.Set("User-Agent", userAgent)
+	request.SetBasicAuth(c.getMail(), c.getToken())
+	request.Close = true
 
-	return nil
+	return request, nil
 }
 
 // validateMetrics validate metrics struct
@@ -608,7 +1306,7 @@ func validateMetrics(m *Metrics) error {
 		return errors.New("Metrics struct is not initialized")
 	}
 
-	return nil
+	return validateTags(m.Tags)
 }
 
 // validateCounter validate counter struct
@@ -642,6 +1340,10 @@ func validateGauge(g Gauge) error {
 
 	switch g.Value.(type) {
 	case int, int32, int64, uint, uint32, uint64, float32, float64:
+	case nil:
+		if g.Count == nil {
+			return errors.New("Gauge property Value can't be non-numeric")
+		}
 	default:
 		return errors.New("Gauge property Value can't be non-numeric")
 	}
@@ -652,6 +1354,12 @@ func validateGauge(g Gauge) error {
 		return errors.New("Gauge property Count can't be non-numeric")
 	}
 
+	count, hasCount := toFloat(g.Count)
+
+	if hasCount && count <= 0 {
+		return errors.New("Gauge property Count must be greater than zero")
+	}
+
 	switch g.Sum.(type) {
 	case int, int32, int64, uint, uint32, uint64, float32, float64, nil:
 	default:
@@ -685,16 +1393,27 @@ func validateAnotation(a Annotation) error {
 		return errors.New("Annotation property Title can't be empty")
 	}
 
+	for _, link := range a.getLinks() {
+		err := link.Validate()
+
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 // extractErrors extracts error descriptions from API response
-func extractErrors(data string) []error {
+func extractErrors(statusCode int, url, data string) []error {
 	var err error
 
+	apiErr := APIError{StatusCode: statusCode, URL: url}
+
 	// Data doesn't looks like JSON. Return raw data
 	if !strings.HasPrefix(data, "{") {
-		return []error{fmt.Errorf(data)}
+		apiErr.Message = data
+		return []error{&apiErr}
 	}
 
 	switch {
@@ -703,54 +1422,77 @@ func extractErrors(data string) []error {
 		err = parseErrorsData(data, errStruct)
 
 		if err != nil {
-			return []error{fmt.Errorf("Can't parse errors data: %v", err)}
+			apiErr.Message = fmt.Sprintf("Can't parse errors data: %v", err)
+			return []error{&apiErr}
 		}
 
-		return mapToErrors(errStruct.Errors.Params)
+		apiErr.Category = ERROR_CATEGORY_PARAMS
+
+		return mapToErrors(apiErr, errStruct.Errors.Params)
 
 	case strings.Contains(data, "\"request\":"):
 		errStruct := &requestErrors{}
 		err = parseErrorsData(data, errStruct)
 
 		if err != nil {
-			return []error{fmt.Errorf("Can't parse errors data: %v", err)}
+			apiErr.Message = fmt.Sprintf("Can't parse errors data: %v", err)
+			return []error{&apiErr}
 		}
 
-		return sliceToErrors(errStruct.Errors.Request)
+		apiErr.Category = ERROR_CATEGORY_REQUEST
+
+		return sliceToErrors(apiErr, errStruct.Errors.Request)
 
 	case strings.Contains(data, "\"system\":"):
 		errStruct := &systemErrors{}
 		err = parseErrorsData(data, errStruct)
 
 		if err != nil {
-			return []error{fmt.Errorf("Can't parse errors data: %v", err)}
+			apiErr.Message = fmt.Sprintf("Can't parse errors data: %v", err)
+			return []error{&apiErr}
 		}
 
-		return sliceToErrors(errStruct.Errors.System)
+		apiErr.Category = ERROR_CATEGORY_SYSTEM
+
+		return sliceToErrors(apiErr, errStruct.Errors.System)
 
 	default:
-		return []error{fmt.Errorf("Unsupported errors data")}
+		apiErr.Message = "Unsupported errors data"
+		return []error{&apiErr}
 	}
 }
 
 // sliceToErrors convert slice with strings to slice with errors
-func sliceToErrors(data []string) []error {
+func sliceToErrors(base APIError, data []string) []error {
 	var result []error
 
-	for _, err := range data {
-		result = append(result, errors.New(err))
+	for _, message := range data {
+		apiErr := base
+		apiErr.Message = message
+		result = append(result, &apiErr)
 	}
 
 	return result
 }
 
 // mapToErrors convert map with prop name and description to slice with errors
-func mapToErrors(data map[string][]string) []error {
+func mapToErrors(base APIError, data map[string][]string) []error {
 	var result []error
 
-	for _, errSlice := range data {
-		for _, err := range errSlice {
-			result = append(result, errors.New(err))
+	params := make([]string, 0, len(data))
+
+	for param := range data {
+		params = append(params, param)
+	}
+
+	sort.Strings(params)
+
+	for _, param := range params {
+		for _, message := range data[param] {
+			apiErr := base
+			apiErr.Param = param
+			apiErr.Message = message
+			result = append(result, &apiErr)
 		}
 	}
 
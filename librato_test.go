@@ -0,0 +1,1844 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/essentialkaos/check"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type LibratoSuite struct {
+	server   *httptest.Server
+	servers  []*httptest.Server
+	received int64
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&LibratoSuite{})
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *LibratoSuite) SetUpSuite(c *C) {
+	s.server = httptest.NewServer(http.HandlerFunc(s.handler))
+}
+
+func (s *LibratoSuite) TearDownSuite(c *C) {
+	s.server.Close()
+
+	for _, server := range s.servers {
+		server.Close()
+	}
+}
+
+func (s *LibratoSuite) SetUpTest(c *C) {
+	atomic.StoreInt64(&s.received, 0)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func (s *LibratoSuite) TestMetricsConcurrentAdd(c *C) {
+	metrics, err := s.newClient().NewMetrics(0, 50)
+
+	c.Assert(err, IsNil)
+	c.Assert(metrics, NotNil)
+
+	const workers, count = 16, 500
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < count; j++ {
+				c.Assert(metrics.Add(Gauge{Name: "test", Value: j}), IsNil)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	c.Assert(metrics.Flush(context.Background()), IsNil)
+
+	metrics.Close()
+
+	c.Assert(atomic.LoadInt64(&s.received), Equals, int64(workers*count))
+}
+
+func (s *LibratoSuite) TestMetricsConcurrentAddAndSend(c *C) {
+	metrics, err := s.newClient().NewMetrics(0, 1000000)
+
+	c.Assert(err, IsNil)
+
+	const workers, count = 8, 1000
+
+	var wg sync.WaitGroup
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				metrics.Send()
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < count; j++ {
+				metrics.Add(Counter{Name: "test", Value: j})
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stop)
+	<-done
+
+	c.Assert(metrics.Flush(context.Background()), IsNil)
+
+	metrics.Close()
+
+	c.Assert(atomic.LoadInt64(&s.received), Equals, int64(workers*count))
+}
+
+func (s *LibratoSuite) TestClientsIsolation(c *C) {
+	client1, api1 := s.newAPIClient(apiRoutes{})
+	client2, api2 := s.newAPIClient(apiRoutes{})
+
+	client2.Mail, client2.Token = "other@domain.com", "efgh5678"
+
+	mail, token, endpoint := Mail, Token, APIEndpoint
+
+	c.Assert(client1.AddMetric(Gauge{Name: "test1", Value: 1}), IsNil)
+	c.Assert(client2.AddMetric(Gauge{Name: "test2", Value: 2}), IsNil)
+
+	c.Assert(api1.requests(), DeepEquals, []string{
+		`POST /v1/metrics/ {"gauges":[{"name":"test1","value":1}]}`,
+	})
+	c.Assert(api2.requests(), DeepEquals, []string{
+		`POST /v1/metrics/ {"gauges":[{"name":"test2","value":2}]}`,
+	})
+
+	user, pass, _ := api1.lastRequest().BasicAuth()
+
+	c.Assert(user+":"+pass, Equals, "test@domain.com:abcd1234")
+
+	user, pass, _ = api2.lastRequest().BasicAuth()
+
+	c.Assert(user+":"+pass, Equals, "other@domain.com:efgh5678")
+
+	c.Assert(Mail, Equals, mail)
+	c.Assert(Token, Equals, token)
+	c.Assert(APIEndpoint, Equals, endpoint)
+	c.Assert(client1.Engine != client2.Engine, Equals, true)
+	c.Assert(client1.Engine != Engine, Equals, true)
+
+	Mail, Token, APIEndpoint = "global@domain.com", "ijkl9012", s.server.URL
+
+	defer func() { Mail, Token, APIEndpoint = mail, token, endpoint }()
+
+	c.Assert(AddMetric(Gauge{Name: "test3", Value: 3}), IsNil)
+	c.Assert(atomic.LoadInt64(&s.received), Equals, int64(1))
+	c.Assert(api1.requests(), HasLen, 1)
+	c.Assert(api2.requests(), HasLen, 1)
+	c.Assert(client1.getMail(), Equals, "test@domain.com")
+	c.Assert(client1.getAPIEndpoint() != APIEndpoint, Equals, true)
+
+	literal := &Client{Mail: "test@domain.com", Token: "abcd1234", APIEndpoint: client1.APIEndpoint}
+
+	c.Assert(literal.AddMetric(Gauge{Name: "test4", Value: 4}), IsNil)
+	c.Assert(literal.getEngine(), NotNil)
+	c.Assert(literal.getEngine() == literal.getEngine(), Equals, true)
+	c.Assert(api1.requests(), HasLen, 2)
+	c.Assert(api1.lastRequest().UserAgent(), Matches, "go-ek-librato/.*")
+
+	client2.Engine = client1.Engine
+	client1.UserAgent, client2.UserAgent = "client1/1.0", "client2/1.0"
+
+	c.Assert(client1.AddMetric(Gauge{Name: "test1", Value: 1}), IsNil)
+	c.Assert(api1.lastRequest().UserAgent(), Equals, "client1/1.0")
+	c.Assert(client2.AddMetric(Gauge{Name: "test2", Value: 2}), IsNil)
+	c.Assert(api2.lastRequest().UserAgent(), Equals, "client2/1.0")
+}
+
+func (s *LibratoSuite) TestShutdown(c *C) {
+	client, api := s.newAPIClient(apiRoutes{})
+
+	metrics, err := client.NewMetrics(time.Hour, 100)
+
+	c.Assert(err, IsNil)
+
+	client.NewCollector(time.Hour, func() []Measurement {
+		return []Measurement{Gauge{Name: "collected", Value: 2}}
+	})
+
+	aggregator := client.NewAggregator(time.Hour)
+
+	c.Assert(metrics.Add(Gauge{Name: "buffered", Value: 1}), IsNil)
+	c.Assert(aggregator.Add(Gauge{Name: "aggregated", Value: 3}), IsNil)
+	c.Assert(getSources(), HasLen, 3)
+
+	c.Assert(Shutdown(context.Background()), IsNil)
+
+	c.Assert(api.requests(), DeepEquals, []string{
+		`POST /v1/metrics/ {"gauges":[{"name":"buffered","value":1}]}`,
+		`POST /v1/metrics/ {"gauges":[{"name":"collected","value":2}]}`,
+		`POST /v1/metrics/ {"gauges":[{"name":"aggregated","count":1,"sum":3,"min":3,"max":3,"sum_squares":9}]}`,
+	})
+
+	sourcesMu.Lock()
+	c.Assert(loopStop, IsNil)
+	sourcesMu.Unlock()
+
+	c.Assert(getSources(), HasLen, 0)
+	c.Assert(Shutdown(context.Background()), IsNil)
+}
+
+func (s *LibratoSuite) TestShutdownDeadline(c *C) {
+	release := make(chan struct{})
+
+	defer close(release)
+
+	client, _ := s.newAPIClient(apiRoutes{
+		"POST /v1/metrics/": func(r *http.Request) (int, string) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+
+			return http.StatusOK, ""
+		},
+	})
+
+	metrics, err := client.NewMetrics(time.Hour, 100)
+
+	c.Assert(err, IsNil)
+	c.Assert(metrics.Add(Gauge{Name: "test", Value: 1}), IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	errs := Shutdown(ctx)
+
+	c.Assert(time.Since(start) < time.Second, Equals, true)
+	c.Assert(errs, Not(HasLen), 0)
+	c.Assert(errors.Is(errs[len(errs)-1], context.DeadlineExceeded), Equals, true)
+	c.Assert(getSources(), HasLen, 0)
+
+	metrics, err = client.NewMetrics(time.Hour, 100)
+
+	c.Assert(err, IsNil)
+	c.Assert(metrics.Add(Gauge{Name: "test", Value: 1}), IsNil)
+
+	defer metrics.Close()
+
+	go metrics.Send()
+
+	for metrics.getQueueSize() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	c.Assert(errors.Is(metrics.waitSending(ctx), context.DeadlineExceeded), Equals, true)
+
+	release <- struct{}{}
+
+	c.Assert(metrics.waitSending(context.Background()), IsNil)
+	c.Assert(metrics.waitSending(context.Background()), IsNil)
+}
+
+func (s *LibratoSuite) TestContextCancellation(c *C) {
+	release := make(chan struct{})
+
+	defer close(release)
+
+	client, _ := s.newAPIClient(apiRoutes{
+		"POST /v1/metrics/": func(r *http.Request) (int, string) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+
+			return http.StatusOK, ""
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	errs := client.AddMetricContext(ctx, Gauge{Name: "test", Value: 1})
+
+	c.Assert(time.Since(start) < time.Second, Equals, true)
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errors.Is(errs[0], context.DeadlineExceeded), Equals, true)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	errs = client.AddMetricContext(ctx, Gauge{Name: "test", Value: 1})
+
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errors.Is(errs[0], context.Canceled), Equals, true)
+
+	sendingTimeout := SendingTimeout
+	SendingTimeout = 50 * time.Millisecond
+
+	defer func() { SendingTimeout = sendingTimeout }()
+
+	metrics, err := client.NewMetrics(0, 100)
+
+	c.Assert(err, IsNil)
+
+	defer metrics.Close()
+
+	handled := make(chan []error, 1)
+
+	metrics.ErrorHandler = func(errs []error) {
+		select {
+		case handled <- errs:
+		default:
+		}
+	}
+
+	c.Assert(metrics.Add(Gauge{Name: "test", Value: 1}), IsNil)
+
+	select {
+	case errs = <-handled:
+		c.Assert(errs, HasLen, 1)
+		c.Assert(errors.Is(errs[0], context.DeadlineExceeded), Equals, true)
+	case <-time.After(5 * time.Second):
+		c.Fatal("Background sending is not interrupted by SendingTimeout")
+	}
+}
+
+func (s *LibratoSuite) TestRetries(c *C) {
+	var attempts int64
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt64(&attempts, 1) < 3 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(503)
+				w.Write([]byte(`{"errors":{"system":["Service unavailable"]}}`))
+			}
+		},
+	))
+
+	defer server.Close()
+
+	client := NewClient("test@domain.com", "abcd1234")
+	client.APIEndpoint = server.URL
+
+	c.Assert(client.AddMetric(Gauge{Name: "test", Value: 1}), HasLen, 1)
+	c.Assert(atomic.LoadInt64(&attempts), Equals, int64(1))
+
+	atomic.StoreInt64(&attempts, 0)
+
+	client.RetryPolicy = NewRetryPolicy(2)
+	client.RetryPolicy.BaseBackoff = time.Millisecond
+
+	c.Assert(client.AddMetric(Gauge{Name: "test", Value: 1}), HasLen, 1)
+	c.Assert(atomic.LoadInt64(&attempts), Equals, int64(2))
+
+	atomic.StoreInt64(&attempts, 0)
+
+	client.RetryPolicy.MaxAttempts = 3
+
+	c.Assert(client.AddMetric(Gauge{Name: "test", Value: 1}), IsNil)
+	c.Assert(atomic.LoadInt64(&attempts), Equals, int64(3))
+
+	atomic.StoreInt64(&attempts, 0)
+
+	c.Assert(client.AddAnnotation("test", Annotation{Title: "test"}), HasLen, 1)
+	c.Assert(atomic.LoadInt64(&attempts), Equals, int64(1))
+}
+
+func (s *LibratoSuite) TestRetryPolicyDelay(c *C) {
+	policy := NewRetryPolicy(5)
+	policy.Jitter = 0
+
+	c.Assert(policy.getDelay(1, 0), Equals, 500*time.Millisecond)
+	c.Assert(policy.getDelay(3, 0), Equals, 2*time.Second)
+	c.Assert(policy.getDelay(20, 0), Equals, 30*time.Second)
+	c.Assert(policy.getDelay(1, 5*time.Second), Equals, 5*time.Second)
+	c.Assert(policy.getDelay(1, time.Hour), Equals, 30*time.Second)
+
+	policy.MaxBackoff = 0
+
+	c.Assert(policy.getDelay(100, 0) > 0, Equals, true)
+
+	c.Assert(parseRetryAfter(http.Header{"Retry-After": []string{"10"}}), Equals, 10*time.Second)
+	c.Assert(parseRetryAfter(http.Header{"Retry-After": []string{"abcd"}}), Equals, time.Duration(0))
+	c.Assert(parseRetryAfter(nil), Equals, time.Duration(0))
+}
+
+func (s *LibratoSuite) TestMetricsRequeue(c *C) {
+	var fail int64 = 1
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch atomic.LoadInt64(&fail) {
+			case 1:
+				w.WriteHeader(503)
+				w.Write([]byte(`{"errors":{"system":["Service unavailable"]}}`))
+			case 2:
+				w.WriteHeader(400)
+				w.Write([]byte(`{"errors":{"params":{"name":["is not present"]}}}`))
+			}
+		},
+	))
+
+	defer server.Close()
+
+	client := NewClient("test@domain.com", "abcd1234")
+	client.APIEndpoint = server.URL
+
+	metrics, err := client.NewMetrics(time.Hour, 100)
+
+	c.Assert(err, IsNil)
+
+	defer metrics.Close()
+
+	metrics.MaxBufferSize = 3
+
+	metrics.Add(Gauge{Name: "test", Value: 1}, Gauge{Name: "test", Value: 2})
+
+	c.Assert(metrics.Send(), HasLen, 1)
+	c.Assert(metrics.getQueueSize(), Equals, 2)
+	c.Assert(metrics.Stats(), DeepEquals, MetricsStats{Requeued: 2})
+
+	metrics.Add(Gauge{Name: "test", Value: 3}, Gauge{Name: "test", Value: 4})
+
+	c.Assert(metrics.getQueueSize(), Equals, 3)
+	c.Assert(metrics.queue[0].(Gauge).Value, Equals, 2)
+	c.Assert(metrics.Stats(), DeepEquals, MetricsStats{Requeued: 2, Dropped: 1})
+
+	metrics.DropPolicy = DROP_NEWEST
+	metrics.Add(Gauge{Name: "test", Value: 5})
+
+	c.Assert(metrics.getQueueSize(), Equals, 3)
+	c.Assert(metrics.queue[2].(Gauge).Value, Equals, 4)
+
+	atomic.StoreInt64(&fail, 0)
+
+	c.Assert(metrics.Send(), IsNil)
+	c.Assert(metrics.getQueueSize(), Equals, 0)
+	c.Assert(metrics.Stats(), DeepEquals, MetricsStats{Requeued: 2, Dropped: 2})
+
+	atomic.StoreInt64(&fail, 2)
+
+	metrics.Add(Gauge{Name: "test", Value: 6}, Gauge{Name: "test", Value: 7})
+
+	c.Assert(metrics.Send(), HasLen, 1)
+	c.Assert(metrics.getQueueSize(), Equals, 0)
+	c.Assert(metrics.Stats(), DeepEquals, MetricsStats{Requeued: 2, Dropped: 4})
+
+	errs, failed, rejected := client.sendBatches(context.Background(), []batch{
+		{path: "/v1/metrics", payload: math.NaN(), data: []Measurement{Gauge{Name: "test", Value: 1}}},
+	}, sendOptions{engine: client.getEngine()})
+
+	c.Assert(errs, HasLen, 1)
+	c.Assert(failed, HasLen, 0)
+	c.Assert(rejected, Equals, 1)
+}
+
+func (s *LibratoSuite) TestBatchSplitting(c *C) {
+	var data []Measurement
+
+	for i := 0; i < 25; i++ {
+		data = append(data, Gauge{Name: "test", Value: i % 10})
+	}
+
+	chunks := chunkMeasurements(data, 10, DEFAULT_MAX_PAYLOAD_SIZE)
+
+	c.Assert(chunks, HasLen, 3)
+	c.Assert(chunks[0], HasLen, 10)
+	c.Assert(chunks[2], HasLen, 5)
+
+	size := getEncodedSize(data[0]) + 1
+	chunks = chunkMeasurements(data, 100, payloadOverhead+size*5)
+
+	c.Assert(chunks, HasLen, 5)
+	c.Assert(chunks[0], HasLen, 5)
+
+	client := s.newClient()
+	client.MaxBatchSize = 4
+	client.MaxParallelRequests = 3
+
+	data = append(data, TaggedMeasurement{Name: "test", Value: 1, Tags: Tags{"host": "web1"}})
+
+	c.Assert(client.createBatches(data, nil), HasLen, 8)
+	c.Assert(client.AddMetric(data...), IsNil)
+	c.Assert(atomic.LoadInt64(&s.received), Equals, int64(25))
+
+	c.Assert(defaultClient.createBatches(data, nil), HasLen, 2)
+	c.Assert(defaultClient.getMaxParallelRequests(), Equals, 1)
+
+	DefaultBatchSize, DefaultMaxParallelRequests = 4, 3
+
+	defer func() {
+		DefaultBatchSize = DEFAULT_MAX_BATCH_SIZE
+		DefaultMaxParallelRequests = 1
+	}()
+
+	c.Assert(defaultClient.createBatches(data, nil), HasLen, 8)
+	c.Assert(defaultClient.getMaxParallelRequests(), Equals, 3)
+
+	maxSize, _ := s.newClient().getBatchLimits()
+
+	c.Assert(maxSize, Equals, DEFAULT_MAX_BATCH_SIZE)
+	c.Assert(s.newClient().getMaxParallelRequests(), Equals, 1)
+}
+
+func (s *LibratoSuite) TestTagsValidation(c *C) {
+	tags := Tags{}
+
+	for i := 0; i < MAX_TAGS; i++ {
+		tags[fmt.Sprintf("tag%d", i)] = "value"
+	}
+
+	c.Assert(validateTags(tags), IsNil)
+
+	tags["extra"] = "value"
+
+	c.Assert(validateTags(tags), NotNil)
+
+	c.Assert(validateTags(Tags{strings.Repeat("a", MAX_TAG_NAME_LENGTH): "1"}), IsNil)
+	c.Assert(validateTags(Tags{strings.Repeat("a", MAX_TAG_NAME_LENGTH+1): "1"}), NotNil)
+	c.Assert(validateTags(Tags{"a": strings.Repeat("1", MAX_TAG_VALUE_LENGTH)}), IsNil)
+	c.Assert(validateTags(Tags{"a": strings.Repeat("1", MAX_TAG_VALUE_LENGTH+1)}), NotNil)
+
+	c.Assert(validateTags(Tags{"region-1.az:a_b": `us-east/1\a b`}), IsNil)
+	c.Assert(validateTags(Tags{"": "1"}), NotNil)
+	c.Assert(validateTags(Tags{"a": ""}), NotNil)
+	c.Assert(validateTags(Tags{"a b": "1"}), NotNil)
+	c.Assert(validateTags(Tags{"a/b": "1"}), NotNil)
+	c.Assert(validateTags(Tags{"a": "1,2"}), NotNil)
+	c.Assert(validateTags(Tags{"a": "x\ny"}), NotNil)
+
+	c.Assert(TaggedMeasurement{Name: "test", Value: 1, Tags: Tags{"a b": "1"}}.Validate(), NotNil)
+}
+
+func (s *LibratoSuite) TestAddMeasurements(c *C) {
+	client, api := s.newAPIClient(apiRoutes{})
+
+	c.Assert(client.AddMeasurements(Tags{"a b": "1"}, TaggedMeasurement{Name: "cpu", Value: 1}), HasLen, 1)
+	c.Assert(client.AddMeasurements(nil, TaggedMeasurement{Name: "cpu", Value: "1"}), HasLen, 1)
+	c.Assert(client.AddMeasurements(nil, TaggedMeasurement{Name: "cpu", Value: 1}), HasLen, 1)
+	c.Assert(client.AddMeasurements(Tags{}, TaggedMeasurement{Name: "cpu", Value: 1}), HasLen, 1)
+	c.Assert(api.requests(), HasLen, 0)
+
+	c.Assert(client.AddMeasurements(
+		Tags{"region": "us-east", "host": "web1"},
+		TaggedMeasurement{Name: "cpu", Value: 1.5, Time: 100},
+		TaggedMeasurement{Name: "cpu", Value: 2, Tags: Tags{"host": "web2"}},
+		TaggedMeasurement{Name: "latency", Count: 2, Sum: 30, Min: 10, Max: 20, Period: 60},
+	), IsNil)
+
+	c.Assert(api.requests(), DeepEquals, []string{
+		`POST /v1/measurements {"tags":{"host":"web1","region":"us-east"},"measurements":[` +
+			`{"name":"cpu","value":1.5,"time":100},` +
+			`{"name":"cpu","value":2,"tags":{"host":"web2"}},` +
+			`{"name":"latency","count":2,"sum":30,"min":10,"max":20,"period":60}]}`,
+	})
+
+	api.reset()
+
+	errs, _, rejected := client.sendMeasurements(context.Background(), []Measurement{
+		TaggedMeasurement{Name: "cpu", Value: 1},
+		Gauge{Name: "mem", Value: 1},
+	}, sendOptions{engine: client.Engine})
+
+	c.Assert(errs, HasLen, 1)
+	c.Assert(rejected, Equals, 1)
+	c.Assert(api.requests(), DeepEquals, []string{
+		`POST /v1/metrics/ {"gauges":[{"name":"mem","value":1}]}`,
+	})
+
+	api.reset()
+
+	untagged, err := client.NewMetrics(time.Hour, 100)
+
+	c.Assert(err, IsNil)
+
+	defer untagged.Close()
+
+	c.Assert(untagged.Add(TaggedMeasurement{Name: "cpu", Value: 1}), NotNil)
+
+	_, err = client.NewTaggedMetrics(time.Hour, 100, Tags{"a b": "1"})
+
+	c.Assert(err, NotNil)
+
+	metrics, err := client.NewTaggedMetrics(time.Hour, 100, Tags{"env": "prod"})
+
+	c.Assert(err, IsNil)
+
+	defer metrics.Close()
+
+	c.Assert(metrics.Add(
+		Gauge{Name: "mem", Value: 1, Source: "web1"},
+		TaggedMeasurement{Name: "cpu", Value: 1, Tags: Tags{"env": "dev"}},
+	), IsNil)
+
+	c.Assert(metrics.Send(), IsNil)
+	c.Assert(api.requests(), DeepEquals, []string{
+		`POST /v1/metrics/ {"gauges":[{"name":"mem","value":1,"source":"web1"}]}`,
+		`POST /v1/measurements {"tags":{"env":"prod"},"measurements":[{"name":"cpu","value":1,"tags":{"env":"dev"}}]}`,
+	})
+}
+
+func (s *LibratoSuite) TestErrorsExtraction(c *C) {
+	errs := extractErrors(400, "https://domain.com", `{"errors":{"params":{"name":["is not present"],"value":["is not a number"]}}}`)
+
+	c.Assert(errs, HasLen, 2)
+	c.Assert(errs[0].Error(), Equals, "name: is not present")
+	c.Assert(errs[1], DeepEquals, &APIError{
+		StatusCode: 400,
+		Category:   ERROR_CATEGORY_PARAMS,
+		Param:      "value",
+		Message:    "is not a number",
+		URL:        "https://domain.com",
+	})
+
+	errs = extractErrors(401, "", `{"errors":{"request":["Authorization Required"]}}`)
+
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errs[0].Error(), Equals, "Authorization Required")
+	c.Assert(errors.Is(errs[0], ErrUnauthorized), Equals, true)
+	c.Assert(errors.Is(errs[0], ErrNotFound), Equals, false)
+
+	errs = extractErrors(503, "", `{"errors":{"system":["Service unavailable"]}}`)
+
+	var apiErr *APIError
+
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errors.As(errs[0], &apiErr), Equals, true)
+	c.Assert(apiErr.Category, Equals, ERROR_CATEGORY_SYSTEM)
+	c.Assert(errors.Is(errs[0], ErrServerError), Equals, true)
+
+	errs = extractErrors(404, "", "")
+
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errs[0].Error(), Equals, "Not Found")
+	c.Assert(errors.Is(errs[0], ErrNotFound), Equals, true)
+
+	errs = extractErrors(429, "", "{}")
+
+	c.Assert(errs[0].Error(), Equals, "Unsupported errors data")
+	c.Assert(errors.Is(errs[0], ErrRateLimited), Equals, true)
+}
+
+func (s *LibratoSuite) TestRateLimits(c *C) {
+	reset := time.Now().Add(time.Hour).Unix()
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Librato-RateLimit-Std", fmt.Sprintf("limit=300,remaining=0,reset=%d", reset))
+			w.Header().Set("X-Librato-RateLimit-Agg", fmt.Sprintf("limit=5000,remaining=4000,reset=%d", reset))
+		},
+	))
+
+	defer server.Close()
+
+	var handled RateLimits
+
+	client := NewClient("test@domain.com", "abcd1234")
+	client.APIEndpoint = server.URL
+	client.RateLimitHandler = func(limits RateLimits) { handled = limits }
+
+	c.Assert(client.RateLimits().Std.IsEmpty(), Equals, true)
+	c.Assert(client.AddMetric(Gauge{Name: "test", Value: 1}), IsNil)
+
+	limits := client.RateLimits()
+
+	c.Assert(handled, DeepEquals, limits)
+	c.Assert(limits.Std, DeepEquals, RateLimit{300, 0, time.Unix(reset, 0)})
+	c.Assert(limits.Agg, DeepEquals, RateLimit{5000, 4000, time.Unix(reset, 0)})
+	c.Assert(limits.Std.IsExceeded(), Equals, true)
+	c.Assert(limits.Agg.IsExceeded(), Equals, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	c.Assert(client.waitRateLimit(ctx), Equals, context.DeadlineExceeded)
+
+	resetTime := time.Now().Add(time.Hour)
+	limit := RateLimit{Limit: 100, Remaining: 50, Reset: resetTime}
+
+	c.Assert(limit.getDelay(), Equals, time.Duration(0))
+
+	limit.Remaining = 5
+	delay := limit.getDelay()
+
+	c.Assert(delay > 9*time.Minute && delay <= 10*time.Minute, Equals, true)
+
+	limit.consume()
+
+	c.Assert(limit.Remaining, Equals, 4)
+	c.Assert(RateLimit{Limit: 100, Reset: time.Now().Add(-time.Second)}.getDelay(), Equals, time.Duration(0))
+
+	c.Assert(parseRateLimit("limit=abc,remaining"), DeepEquals, RateLimit{})
+
+	mail, token, endpoint := Mail, Token, APIEndpoint
+	Mail, Token, APIEndpoint = "test@domain.com", "abcd1234", server.URL
+
+	var globalHandled RateLimits
+
+	RateLimitHandler = func(limits RateLimits) { globalHandled = limits }
+
+	defer func() {
+		Mail, Token, APIEndpoint = mail, token, endpoint
+		RateLimitHandler = nil
+		defaultClient.rateLimits = RateLimits{}
+	}()
+
+	c.Assert(AddMetric(Gauge{Name: "test", Value: 1}), IsNil)
+	c.Assert(GetRateLimits(), DeepEquals, limits)
+	c.Assert(globalHandled, DeepEquals, limits)
+
+	limitedClient, api := s.newAPIClient(apiRoutes{})
+	limitedClient.rateLimits.Std = RateLimit{Limit: 300, Remaining: 0, Reset: time.Now().Add(time.Hour)}
+
+	metrics, err := limitedClient.NewMetrics(time.Hour, 2)
+
+	c.Assert(err, IsNil)
+
+	defer metrics.Close()
+
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		c.Assert(metrics.Add(Gauge{Name: "test", Value: i}), IsNil)
+	}
+
+	c.Assert(time.Since(start) < time.Second, Equals, true)
+	c.Assert(api.requests(), HasLen, 0)
+	c.Assert(metrics.getQueueSize(), Equals, 5)
+	c.Assert(metrics.getLastSendingDate(), Equals, int64(0))
+}
+
+func (s *LibratoSuite) TestAggregator(c *C) {
+	aggregator := s.newClient().NewAggregator(time.Hour)
+
+	defer aggregator.Close()
+
+	c.Assert(aggregator.Add(Counter{Name: "test", Value: 1}), NotNil)
+
+	for _, v := range []int{1, 2, 3, 6} {
+		c.Assert(aggregator.Add(
+			Gauge{Name: "test", Value: v},
+			Gauge{Name: "test", Value: v, Source: "abcd"},
+			TaggedMeasurement{Name: "test", Value: v, Tags: Tags{"a": "1", "b": "2"}},
+			TaggedMeasurement{Name: "test", Value: v, Tags: Tags{"b": "2", "a": "1"}},
+		), IsNil)
+	}
+
+	data := aggregator.takeMeasurements()
+
+	c.Assert(data, HasLen, 3)
+	c.Assert(data[0], DeepEquals, Gauge{
+		Name: "test", Count: 4.0, Sum: 12.0,
+		Min: 1.0, Max: 6.0, SumSquares: 50.0,
+	})
+	c.Assert(data[1].(Gauge).Source, Equals, "abcd")
+	c.Assert(data[2], DeepEquals, TaggedMeasurement{
+		Name: "test", Tags: Tags{"a": "1", "b": "2"},
+		Count: 8.0, Sum: 24.0, Min: 1.0, Max: 6.0,
+		Last: 6.0, StdDev: math.Sqrt(50.0/4 - 9), Period: 3600,
+	})
+
+	c.Assert(data[0].Validate(), IsNil)
+	c.Assert(data[2].Validate(), IsNil)
+	c.Assert(aggregator.takeMeasurements(), HasLen, 0)
+
+	aggregator.Add(Gauge{Name: "test", Count: 2, Sum: 10})
+	aggregator.Add(Gauge{Name: "test", Value: 3})
+
+	c.Assert(aggregator.takeMeasurements(), DeepEquals, []Measurement{
+		Gauge{Name: "test", Count: 3.0, Sum: 13.0},
+	})
+
+	c.Assert(aggregator.Add(Gauge{Name: "test", Count: 0, Sum: 0}), NotNil)
+	c.Assert(aggregator.Add(TaggedMeasurement{Name: "test", Count: 0, Sum: 0}), NotNil)
+
+	aggregator.getAggregate("empty", "", nil, false).addSummary(0, 0, 0, 0, 0, false, false)
+	aggregator.Add(TaggedMeasurement{Name: "test", Count: 2, Sum: 4})
+
+	data = aggregator.takeMeasurements()
+
+	c.Assert(data, DeepEquals, []Measurement{
+		TaggedMeasurement{Name: "test", Count: 2.0, Sum: 4.0, Period: 3600},
+	})
+
+	aggregator.Add(Gauge{Name: "test", Value: 0})
+
+	payload, err := json.Marshal(aggregator.takeMeasurements())
+
+	c.Assert(err, IsNil)
+	c.Assert(string(payload), Equals, `[{"name":"test","count":1,"sum":0,"min":0,"max":0,"sum_squares":0}]`)
+
+	aggregator.Add(
+		Gauge{Name: "test", Value: math.NaN()},
+		Gauge{Name: "test", Count: 1, Sum: math.Inf(1)},
+		TaggedMeasurement{Name: "test", Value: math.Inf(-1), Tags: Tags{"a": "1"}},
+		TaggedMeasurement{Name: "test", Count: 1, Sum: 1, Last: math.NaN(), Tags: Tags{"a": "1"}},
+		Gauge{Name: "test", Value: 2},
+	)
+
+	c.Assert(aggregator.takeMeasurements(), DeepEquals, []Measurement{
+		Gauge{Name: "test", Count: 1.0, Sum: 2.0, Min: 2.0, Max: 2.0, SumSquares: 4.0},
+		TaggedMeasurement{Name: "test", Count: 1.0, Sum: 1.0, Tags: Tags{"a": "1"}, Period: 3600},
+	})
+}
+
+func (s *LibratoSuite) TestRegistry(c *C) {
+	registry := s.newClient().NewRegistry(time.Hour)
+
+	defer registry.Close()
+
+	counter, err := registry.Counter("test", "")
+
+	c.Assert(err, IsNil)
+
+	gauge, err := registry.Gauge("test", "abcd")
+
+	c.Assert(err, IsNil)
+
+	timer, err := registry.Timer("timer", "")
+
+	c.Assert(err, IsNil)
+
+	sameCounter, _ := registry.Counter("test", "")
+
+	c.Assert(sameCounter, Equals, counter)
+	c.Assert(registry.snapshot(), DeepEquals, []Measurement{
+		Counter{Name: "test", Value: int64(0)},
+	})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				counter.Inc()
+				counter.Add(2)
+				gauge.Update(0.5)
+				timer.Record(time.Millisecond)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	data := registry.snapshot()
+
+	c.Assert(data, HasLen, 3)
+	c.Assert(data[0], DeepEquals, Counter{Name: "test", Value: int64(3000)})
+	c.Assert(data[1], DeepEquals, Gauge{Name: "test", Source: "abcd", Value: 500.0})
+	c.Assert(data[2], DeepEquals, Gauge{
+		Name: "timer", Count: 1000.0, Sum: 1000.0,
+		Min: 1.0, Max: 1.0, SumSquares: 1000.0,
+	})
+
+	gauge.Set(10)
+
+	data = registry.snapshot()
+
+	c.Assert(data, HasLen, 2)
+	c.Assert(data[1], DeepEquals, Gauge{Name: "test", Source: "abcd", Value: 10.0})
+
+	c.Assert(registry.Send(), IsNil)
+	c.Assert(atomic.LoadInt64(&s.received), Equals, int64(2))
+
+	_, err = registry.Counter("", "")
+	c.Assert(err, NotNil)
+	_, err = registry.Gauge(strings.Repeat("a", 256), "")
+	c.Assert(err, NotNil)
+	_, err = registry.Timer("timer", strings.Repeat("a", 256))
+	c.Assert(err, NotNil)
+	_, err = registry.Histogram("", "")
+	c.Assert(err, NotNil)
+
+	gauge.Set(math.NaN())
+	timer.Record(time.Millisecond)
+
+	c.Assert(registry.snapshot(), DeepEquals, []Measurement{
+		Counter{Name: "test", Value: int64(3000)},
+		Gauge{Name: "timer", Count: 1.0, Sum: 1.0, Min: 1.0, Max: 1.0, SumSquares: 1.0},
+	})
+
+	gauge.Set(math.Inf(1))
+
+	c.Assert(registry.snapshot(), HasLen, 1)
+}
+
+func (s *LibratoSuite) TestHistogram(c *C) {
+	h := NewHistogram("latency", "abcd")
+
+	c.Assert(h.Snapshot(), IsNil)
+
+	for i := 1000; i > 0; i-- {
+		h.Update(float64(i))
+	}
+
+	c.Assert(h.Count(), Equals, uint64(1000))
+	c.Assert(math.Abs(h.Quantile(0.5)-500)/500 <= HISTOGRAM_ACCURACY, Equals, true)
+	c.Assert(math.Abs(h.Quantile(0.99)-990)/990 <= HISTOGRAM_ACCURACY, Equals, true)
+	c.Assert(math.Abs(h.Quantile(1)-1000)/1000 <= HISTOGRAM_ACCURACY, Equals, true)
+
+	data := h.Snapshot()
+
+	c.Assert(data, HasLen, 4)
+	c.Assert(data[0].(Gauge).Count, Equals, 1000.0)
+	c.Assert(data[0].(Gauge).Min, Equals, 1.0)
+	c.Assert(data[1].(Gauge).Name, Equals, "latency.p50")
+	c.Assert(data[3].(Gauge).Name, Equals, "latency.p99")
+	c.Assert(data[3].(Gauge).Source, Equals, "abcd")
+	c.Assert(h.Count(), Equals, uint64(0))
+
+	h = NewHistogram("test", "", 0, 99.9, 101, math.NaN())
+
+	for _, v := range []float64{-10, -5, 0, 5, 10, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		h.Update(v)
+	}
+
+	c.Assert(h.Count(), Equals, uint64(5))
+	c.Assert(h.Dropped(), Equals, uint64(3))
+	c.Assert(math.Abs(h.Quantile(0)+10) <= 0.1, Equals, true)
+	c.Assert(h.Quantile(0.5), Equals, 0.0)
+
+	data = h.Snapshot()
+
+	c.Assert(data, HasLen, 2)
+	c.Assert(data[0].(Gauge).Max, Equals, 10.0)
+	c.Assert(data[1].(Gauge).Name, Equals, "test.p99.9")
+
+	c.Assert(NewHistogram("test", "", -1, 0).percentiles, DeepEquals, DefaultPercentiles)
+
+	registry := s.newClient().NewRegistry(time.Hour)
+
+	defer registry.Close()
+
+	histogram, err := registry.Histogram("test", "")
+
+	c.Assert(err, IsNil)
+
+	histogram.UpdateDuration(time.Second)
+
+	sameHistogram, _ := registry.Histogram("test", "")
+
+	c.Assert(sameHistogram, Equals, histogram)
+	c.Assert(registry.snapshot(), HasLen, 4)
+
+	h = NewHistogram("wide", "")
+
+	for i := 0; i <= HISTOGRAM_MAX_BUCKETS*2; i++ {
+		h.Update(math.Pow(1.05, float64(i)))
+		h.Update(-math.Pow(1.05, float64(i)))
+	}
+
+	max := math.Pow(1.05, HISTOGRAM_MAX_BUCKETS*2)
+
+	c.Assert(h.sketch.positive.counts, HasLen, HISTOGRAM_MAX_BUCKETS)
+	c.Assert(h.sketch.negative.counts, HasLen, HISTOGRAM_MAX_BUCKETS)
+	c.Assert(h.Count(), Equals, uint64(HISTOGRAM_MAX_BUCKETS*4+2))
+	c.Assert(math.Abs(h.Quantile(1)-max)/max <= HISTOGRAM_ACCURACY, Equals, true)
+	c.Assert(math.Abs(h.Quantile(0)+max)/max <= HISTOGRAM_ACCURACY, Equals, true)
+
+	// Histogram isn't a measurement, its gauges must be added to metrics
+	var m interface{} = h
+
+	_, isMeasurement := m.(Measurement)
+
+	c.Assert(isMeasurement, Equals, false)
+
+	metrics, err := s.newClient().NewMetrics(time.Hour, 100)
+
+	c.Assert(err, IsNil)
+
+	defer metrics.Close()
+
+	c.Assert(metrics.Add(h.Snapshot()...), IsNil)
+	c.Assert(metrics.getQueueSize(), Equals, 4)
+}
+
+func (s *LibratoSuite) TestMetricsMetadata(c *C) {
+	client, api := s.newAPIClient(apiRoutes{
+		"GET /v1/metrics/test":    reply(`{"name":"test","type":"gauge","display_name":"Test","period":60,"attributes":{"display_units_short":"ms","display_max":100}}`),
+		"GET /v1/metrics":         reply(`{"query":{"found":3,"length":1,"offset":1,"total":10},"metrics":[{"name":"test1"}]}`),
+		"GET /v1/metrics/unknown": replyWithStatus(http.StatusNotFound, `{"errors":{"request":["Metric not found"]}}`),
+	})
+
+	metric, errs := client.GetMetric("test")
+
+	c.Assert(errs, IsNil)
+	c.Assert(metric.DisplayName, Equals, "Test")
+	c.Assert(metric.Attributes.DisplayUnitsShort, Equals, "ms")
+	c.Assert(metric.Attributes.DisplayMax, Equals, 100.0)
+
+	_, errs = client.GetMetric("unknown")
+
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errors.Is(errs[0], ErrNotFound), Equals, true)
+
+	list, errs := client.ListMetrics(ListMetricsOptions{Name: "test", Pagination: Pagination{Offset: 1, Length: 1}})
+
+	c.Assert(errs, IsNil)
+	c.Assert(list.Metrics, HasLen, 1)
+	c.Assert(list.Query.HasMore(), Equals, true)
+	c.Assert(Pagination{Length: 1}.Next(list.Query).Offset, Equals, 2)
+
+	c.Assert(client.UpdateMetric(Metric{Name: "test", Attributes: &MetricAttributes{Color: "red"}}), HasLen, 1)
+	c.Assert(client.UpdateMetric(Metric{Name: "test", Type: METRIC_TYPE_COMPOSITE}), HasLen, 1)
+	c.Assert(client.UpdateMetric(Metric{Name: "test", Attributes: &MetricAttributes{SummarizeFunction: "abc"}}), HasLen, 1)
+
+	disabled := false
+
+	c.Assert(client.UpdateMetric(Metric{
+		Name: "test", Type: METRIC_TYPE_GAUGE,
+		Attributes: &MetricAttributes{Color: "#FF0000", DisplayUnitsShort: "ms", Aggregate: &disabled},
+	}), IsNil)
+
+	c.Assert(client.DeleteMetrics(), NotNil)
+	c.Assert(client.DeleteMetrics("test1", "test2"), IsNil)
+
+	c.Assert(api.requests(), DeepEquals, []string{
+		"GET /v1/metrics/test ",
+		"GET /v1/metrics/unknown ",
+		"GET /v1/metrics?length=1&name=test&offset=1 ",
+		`PUT /v1/metrics/test {"name":"test","type":"gauge","attributes":{"color":"#FF0000","display_units_short":"ms","aggregate":false}}`,
+		`DELETE /v1/metrics {"names":["test1","test2"]}`,
+	})
+}
+
+func (s *LibratoSuite) TestQueryMeasurements(c *C) {
+	client, api := s.newAPIClient(apiRoutes{
+		"GET /v1/measurements/cpu": reply(`{"name":"cpu","resolution":60,"series":[{"tags":{"host":"a"},"measurements":[{"time":100,"value":1.5},{"time":160,"value":2}]}],"query":{"next_time":220}}`),
+		"GET /v1/metrics/cpu":      reply(`{"resolution":60,"measurements":{"b":[{"measure_time":100,"value":3,"count":2,"sum":6}],"a":[{"measure_time":100,"value":1}]}}`),
+	})
+
+	_, errs := client.QueryMeasurements("", QueryOptions{})
+	c.Assert(errs, HasLen, 1)
+	_, errs = client.QueryMeasurements("cpu", QueryOptions{})
+	c.Assert(errs, HasLen, 1)
+	_, errs = client.QueryMeasurements("cpu", QueryOptions{Duration: 3600})
+	c.Assert(errs, HasLen, 1)
+	_, errs = client.QueryMeasurements("cpu", QueryOptions{Duration: 3600, Sources: []string{"a"}, GroupBy: "host"})
+	c.Assert(errs, HasLen, 1)
+
+	opts := QueryOptions{
+		StartTime: 100, Resolution: 60, Tags: Tags{"host": "a"},
+		GroupBy: "host", GroupByFunction: "sum",
+	}
+
+	result, errs := client.QueryMeasurements("cpu", opts)
+
+	c.Assert(errs, IsNil)
+	c.Assert(result.Resolution, Equals, 60)
+	c.Assert(result.Series, HasLen, 1)
+	c.Assert(result.Series[0].Tags, DeepEquals, Tags{"host": "a"})
+	c.Assert(result.Series[0].Points, DeepEquals, []Point{{Time: 100, Value: 1.5}, {Time: 160, Value: 2}})
+	c.Assert(opts.Next(result).StartTime, Equals, int64(220))
+
+	result, errs = client.QueryMeasurements("cpu", QueryOptions{
+		Duration: 3600, Sources: []string{"a", "b"}, SummarizeTime: 300,
+	})
+
+	c.Assert(errs, IsNil)
+	c.Assert(result.Name, Equals, "cpu")
+	c.Assert(result.Series, HasLen, 2)
+	c.Assert(result.Series[0].Source, Equals, "a")
+	c.Assert(result.Series[1].Points, DeepEquals, []Point{{Time: 100, Value: 3, Count: 2, Sum: 6}})
+	c.Assert(result.NextTime, Equals, int64(0))
+
+	c.Assert(api.requests(), DeepEquals, []string{
+		"GET /v1/measurements/cpu?group_by=host&group_by_function=sum&resolution=60&start_time=100&tags%5Bhost%5D=a ",
+		"GET /v1/metrics/cpu?duration=3600&sources%5B%5D=a&sources%5B%5D=b&summarize_time=300 ",
+	})
+}
+
+func (s *LibratoSuite) TestComposite(c *C) {
+	c.Assert(S("api.*", "*").String(), Equals, `s("api.*", "*")`)
+	c.Assert(S("cpu", `we"ird`, CompositeOptions{"period": "60", "function": "max"}).String(),
+		Equals, `s("cpu", "we\"ird", {function:"max", period:"60"})`)
+	c.Assert(Sum(STags("cpu", Tags{"region": "us*", "env": "prod"})).String(),
+		Equals, `sum(s("cpu", {"env":"prod", "region":"us*"}))`)
+	c.Assert(Divide(S("a", "*"), S("b", "*")).String(), Equals, `divide([s("a", "*"), s("b", "*")])`)
+	c.Assert(Derive(S("a", "*"), true).String(), Equals, `derive(s("a", "*"), {detect_reset:"true"})`)
+	c.Assert(Scale(S("a", "*"), 0.5).String(), Equals, `scale(s("a", "*"), {factor:"0.5"})`)
+	c.Assert(Timeshift("1d", S("a", "*")).String(), Equals, `timeshift("1d", s("a", "*"))`)
+
+	client, api := s.newAPIClient(apiRoutes{
+		"GET /v1/measurements": reply(`{"resolution":60,"measurements":[{"series":[{"time":100,"value":4}],"query":{"metric":"api.req","tags":{"host":"a"}}}],"query":{"next_time":160}}`),
+	})
+
+	_, errs := client.QueryComposite("", QueryOptions{Duration: 60, Resolution: 60})
+	c.Assert(errs, HasLen, 1)
+	_, errs = client.QueryComposite(S("a", "*"), QueryOptions{Duration: 60, Resolution: 60, GroupBy: "host"})
+	c.Assert(errs, HasLen, 1)
+
+	result, errs := client.QueryComposite(Sum(S("api.*", "*")), QueryOptions{Duration: 60, Resolution: 60})
+
+	c.Assert(errs, IsNil)
+	c.Assert(api.lastRequest().URL.Query().Get("compose"), Equals, `sum(s("api.*", "*"))`)
+	c.Assert(result.NextTime, Equals, int64(160))
+	c.Assert(result.Series, HasLen, 1)
+	c.Assert(result.Series[0].Metric, Equals, "api.req")
+	c.Assert(result.Series[0].Tags, DeepEquals, Tags{"host": "a"})
+	c.Assert(result.Series[0].Points, DeepEquals, []Point{{Time: 100, Value: 4}})
+}
+
+func (s *LibratoSuite) TestAnnotations(c *C) {
+	client, api := s.newAPIClient(apiRoutes{
+		"POST /v1/annotations/deploys":     replyWithStatus(http.StatusCreated, `{"id":123,"title":"v1","start_time":100}`),
+		"GET /v1/annotations":              reply(`{"query":{"found":1,"length":1,"offset":0,"total":1},"annotations":[{"name":"deploys","display_name":"Deploys"}]}`),
+		"GET /v1/annotations/deploys":      reply(`{"name":"deploys","display_name":"Deploys","events":[{"web1":[{"id":2,"title":"b"}],"unassigned":[{"id":1,"title":"a"}]}]}`),
+		"GET /v1/annotations/deploys/123":  reply(`{"id":123,"title":"v1","start_time":100,"end_time":200}`),
+		"POST /v1/annotations/app deploys": replyWithStatus(http.StatusCreated, `{"id":124,"title":"v2"}`),
+	})
+
+	event, errs := client.CreateAnnotationEvent("deploys", Annotation{Title: "v1", StartTime: 100})
+
+	c.Assert(errs, IsNil)
+	c.Assert(event.ID, Equals, int64(123))
+	c.Assert(event.Title, Equals, "v1")
+
+	event.EndTime = 200
+
+	c.Assert(client.UpdateAnnotationEvent("deploys", event.ID, event.Annotation), IsNil)
+	c.Assert(client.UpdateAnnotationEvent("deploys", 0, event.Annotation), HasLen, 1)
+	c.Assert(client.UpdateAnnotationStream("deploys", "Deploys"), IsNil)
+	c.Assert(client.DeleteAnnotationEvent("deploys", 123), IsNil)
+	c.Assert(client.DeleteAnnotationEvent("", 123), HasLen, 1)
+
+	event, errs = client.GetAnnotationEvent("deploys", 123)
+
+	c.Assert(errs, IsNil)
+	c.Assert(event.EndTime, Equals, int64(200))
+
+	list, errs := client.ListAnnotationStreams(ListAnnotationStreamsOptions{})
+
+	c.Assert(errs, IsNil)
+	c.Assert(list.Streams, HasLen, 1)
+	c.Assert(list.Streams[0].DisplayName, Equals, "Deploys")
+
+	stream, errs := client.GetAnnotationStream("deploys", AnnotationStreamOptions{StartTime: 100})
+
+	c.Assert(errs, IsNil)
+	c.Assert(stream.Events, HasLen, 2)
+	c.Assert(stream.Events[0].ID, Equals, int64(1))
+	c.Assert(stream.Events[0].Source, Equals, "")
+	c.Assert(stream.Events[1].Source, Equals, "web1")
+
+	c.Assert(client.AddAnnotation("app deploys", Annotation{Title: "v2"}), IsNil)
+	c.Assert(client.AddAnnotation("", Annotation{Title: "v2"}), HasLen, 1)
+	c.Assert(client.DeleteAnnotations("app deploys"), IsNil)
+
+	c.Assert(api.changes(), DeepEquals, []string{
+		`POST /v1/annotations/deploys {"title":"v1","start_time":100}`,
+		`PUT /v1/annotations/deploys/123 {"title":"v1","start_time":100,"end_time":200}`,
+		`PUT /v1/annotations/deploys {"display_name":"Deploys"}`,
+		`DELETE /v1/annotations/deploys/123 `,
+		`POST /v1/annotations/app%20deploys {"title":"v2"}`,
+		`DELETE /v1/annotations/app%20deploys `,
+	})
+}
+
+func (s *LibratoSuite) TestAnnotationLinks(c *C) {
+	a := Annotation{
+		Title:           "v1",
+		AnnotationLinks: []AnnotationLink{{Rel: "ci", Label: "Build", Href: "https://ci.domain.com/1"}},
+		Links:           []string{"https://git.domain.com/abcd"},
+	}
+
+	c.Assert(validateAnotation(a), IsNil)
+
+	data, err := json.Marshal(a)
+
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `{"links":[{"rel":"ci","label":"Build","href":"https://ci.domain.com/1"},{"rel":"link","href":"https://git.domain.com/abcd"}],"title":"v1"}`)
+
+	event := &AnnotationEvent{}
+	err = json.Unmarshal([]byte(`{"id":7,"title":"v2","links":[{"rel":"ci","href":"https://ci.domain.com/2"},"https://git.domain.com/ef"]}`), event)
+
+	c.Assert(err, IsNil)
+	c.Assert(event.ID, Equals, int64(7))
+	c.Assert(event.Title, Equals, "v2")
+	c.Assert(event.AnnotationLinks, DeepEquals, []AnnotationLink{
+		{Rel: "ci", Href: "https://ci.domain.com/2"},
+		{Rel: DEFAULT_LINK_REL, Href: "https://git.domain.com/ef"},
+	})
+
+	data, err = json.Marshal(event)
+
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `{"id":7,"links":[{"rel":"ci","href":"https://ci.domain.com/2"},{"rel":"link","href":"https://git.domain.com/ef"}],"title":"v2"}`)
+
+	c.Assert(validateAnotation(Annotation{Title: "v1", Links: []string{""}}), NotNil)
+	c.Assert(validateAnotation(Annotation{Title: "v1", Links: []string{"/build/1"}}), NotNil)
+	c.Assert(AnnotationLink{Href: "https://ci.domain.com/1"}.Validate(), NotNil)
+}
+
+func (s *LibratoSuite) TestAnnotationHandle(c *C) {
+	client, api := s.newAPIClient(apiRoutes{
+		"POST /v1/annotations/deploys":   replyWithStatus(http.StatusCreated, `{"id":42}`),
+		"GET /v1/annotations/deploys/42": reply(`{"id":42,"title":"Deploy","start_time":100}`),
+	})
+
+	h, errs := client.BeginAnnotation("deploys", Annotation{Title: "Deploy", StartTime: 100})
+
+	c.Assert(errs, IsNil)
+	c.Assert(h.ID(), Equals, int64(42))
+	c.Assert(h.Stream(), Equals, "deploys")
+
+	file := c.MkDir() + "/annotation.json"
+
+	c.Assert(h.Save(file), IsNil)
+
+	h, errs = client.LoadAnnotation(file)
+
+	c.Assert(errs, IsNil)
+	c.Assert(h.ID(), Equals, int64(42))
+	c.Assert(h.EndAt(context.Background(), time.Unix(50, 0)), HasLen, 1)
+	c.Assert(h.EndAt(context.Background(), time.Unix(200, 0)), IsNil)
+
+	_, err := os.Stat(file)
+
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(api.changes(), DeepEquals, []string{
+		`POST /v1/annotations/deploys {"title":"Deploy","start_time":100}`,
+		`PUT /v1/annotations/deploys/42 {"title":"Deploy","start_time":100,"end_time":200}`,
+	})
+
+	_, errs = client.LoadAnnotation(file)
+
+	c.Assert(errs, HasLen, 1)
+}
+
+func (s *LibratoSuite) TestAlerts(c *C) {
+	client, api := s.newAPIClient(apiRoutes{
+		"POST /v1/alerts":         replyWithStatus(http.StatusCreated, `{"id":5,"name":"api.latency","conditions":[{"type":"above","metric_name":"api.latency","threshold":200}],"services":[{"id":17,"type":"slack","title":"Ops"}],"active":true}`),
+		"GET /v1/alerts":          reply(`{"query":{"found":1,"length":1,"offset":0,"total":1},"alerts":[{"id":5,"name":"api.latency"}]}`),
+		"GET /v1/alerts/5/status": reply(`{"alert":{"id":5},"status":"triggered"}`),
+		"GET /v1/alerts/6":        replyWithStatus(http.StatusNotFound, `{"errors":{"request":["Alert not found"]}}`),
+	})
+
+	active := true
+
+	alert := Alert{
+		Name: "api.latency",
+		Conditions: []*AlertCondition{
+			{Type: ALERT_CONDITION_ABOVE, MetricName: "api.latency", Threshold: 200},
+		},
+		Services: []*AlertService{{ID: 17}},
+		Active:   &active,
+	}
+
+	created, errs := client.CreateAlert(alert)
+
+	c.Assert(errs, IsNil)
+	c.Assert(created.ID, Equals, int64(5))
+	c.Assert(created.Services[0].Type, Equals, "slack")
+
+	c.Assert(client.UpdateAlert(*created), IsNil)
+	c.Assert(client.UpdateAlert(alert), HasLen, 1)
+
+	list, errs := client.ListAlerts(ListAlertsOptions{Name: "api"})
+
+	c.Assert(errs, IsNil)
+	c.Assert(list.Alerts, HasLen, 1)
+
+	status, errs := client.AlertStatus(5)
+
+	c.Assert(errs, IsNil)
+	c.Assert(status, Equals, ALERT_STATUS_TRIGGERED)
+
+	c.Assert(client.ResolveAlert(5), IsNil)
+	c.Assert(client.DeleteAlert(5), IsNil)
+
+	_, errs = client.GetAlert(6)
+
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errors.Is(errs[0], ErrNotFound), Equals, true)
+
+	c.Assert(api.requests(), DeepEquals, []string{
+		`POST /v1/alerts {"name":"api.latency","conditions":[{"type":"above","metric_name":"api.latency","threshold":200}],"services":[17],"active":true}`,
+		`PUT /v1/alerts/5 {"name":"api.latency","conditions":[{"type":"above","metric_name":"api.latency","threshold":200}],"services":[17],"active":true}`,
+		`GET /v1/alerts?name=api&version=2 `,
+		`GET /v1/alerts/5/status `,
+		`POST /v1/alerts/5/clear `,
+		`DELETE /v1/alerts/5 `,
+		`GET /v1/alerts/6 `,
+	})
+
+	alert.Conditions[0].Type = ALERT_CONDITION_ABSENT
+	c.Assert(alert.Validate(), NotNil)
+	alert.Conditions[0].Duration = 300
+	c.Assert(alert.Validate(), IsNil)
+	alert.Conditions[0].DetectReset = true
+	c.Assert(alert.Validate(), NotNil)
+	c.Assert(Alert{Name: "test"}.Validate(), NotNil)
+}
+
+func (s *LibratoSuite) TestServices(c *C) {
+	client, api := s.newAPIClient(apiRoutes{
+		"POST /v1/services": replyWithStatus(http.StatusCreated, `{"id":3,"type":"mail","title":"Ops","settings":{"addresses":"a@domain.com, b@domain.com"}}`),
+		"GET /v1/services":  reply(`{"query":{"found":2,"length":2,"offset":0,"total":2},"services":[{"id":4,"type":"slack","title":"Slack","settings":{"url":"https://hooks.slack.com/x"}},{"id":5,"type":"opsgenie","title":"Genie","settings":{"key":"abcd","port":1}}]}`),
+	})
+
+	service := Service{
+		Title:    "Ops",
+		Settings: &MailSettings{Addresses: []string{"a@domain.com", "b@domain.com"}},
+	}
+
+	created, errs := client.CreateService(service)
+
+	c.Assert(errs, IsNil)
+	c.Assert(created.ID, Equals, int64(3))
+	c.Assert(created.Settings, DeepEquals, service.Settings)
+
+	created.Title = "Ops Team"
+
+	c.Assert(client.UpdateService(*created), IsNil)
+	c.Assert(client.UpdateService(service), HasLen, 1)
+	c.Assert(client.DeleteService(3), IsNil)
+
+	list, errs := client.ListServices(ListServicesOptions{})
+
+	c.Assert(errs, IsNil)
+	c.Assert(list.Services, HasLen, 2)
+	c.Assert(list.Services[0].Settings, DeepEquals, &SlackSettings{URL: "https://hooks.slack.com/x"})
+	c.Assert(list.Services[1].Settings.Type(), Equals, "opsgenie")
+	c.Assert(list.Services[1].Settings.(*CustomServiceSettings).Values["key"], Equals, "abcd")
+
+	c.Assert(api.changes(), DeepEquals, []string{
+		`POST /v1/services {"type":"mail","title":"Ops","settings":{"addresses":"a@domain.com,b@domain.com"}}`,
+		`PUT /v1/services/3 {"id":3,"type":"mail","title":"Ops Team","settings":{"addresses":"a@domain.com,b@domain.com"}}`,
+		`DELETE /v1/services/3 `,
+	})
+
+	c.Assert(Service{Title: "A"}.Validate(), NotNil)
+	c.Assert(Service{Title: "A", Settings: &MailSettings{Addresses: []string{"abcd"}}}.Validate(), NotNil)
+	c.Assert(Service{Title: "A", Settings: &SlackSettings{URL: "hooks.slack.com"}}.Validate(), NotNil)
+	c.Assert(Service{Title: "A", Settings: &WebhookSettings{URL: "https://domain.com/hook"}}.Validate(), IsNil)
+	c.Assert(Service{Title: "A", Settings: &PagerDutySettings{}}.Validate(), NotNil)
+}
+
+func (s *LibratoSuite) TestSpaces(c *C) {
+	client, api := s.newAPIClient(apiRoutes{
+		"POST /v1/spaces":           replyWithStatus(http.StatusCreated, `{"id":10,"name":"API"}`),
+		"GET /v1/spaces":            reply(`{"query":{"found":1,"length":1,"offset":0,"total":1},"spaces":[{"id":10,"name":"API"}]}`),
+		"GET /v1/spaces/10":         reply(`{"id":10,"name":"API","charts":[{"id":20}]}`),
+		"POST /v1/spaces/10/charts": replyWithStatus(http.StatusCreated, `{"id":20,"name":"Latency","type":"line","streams":[{"id":30,"metric":"api.latency","tags":[{"name":"region","values":["eu"]}]}]}`),
+		"GET /v1/spaces/10/charts":  reply(`[{"id":20,"name":"Latency","type":"line","max":500}]`),
+	})
+
+	space, errs := client.CreateSpace(Space{Name: "API"})
+
+	c.Assert(errs, IsNil)
+	c.Assert(space.ID, Equals, int64(10))
+
+	chart := Chart{
+		Name: "Latency",
+		Type: CHART_TYPE_LINE,
+		Streams: []*ChartStream{
+			NewChartStream(TaggedMeasurement{Name: "api.latency", Tags: Tags{"region": "eu"}}),
+		},
+		Thresholds: []*ChartThreshold{{Value: 200, Color: "#ff0000"}},
+	}
+
+	created, errs := client.CreateChart(space.ID, chart)
+
+	c.Assert(errs, IsNil)
+	c.Assert(created.ID, Equals, int64(20))
+	c.Assert(created.Streams[0].ID, Equals, int64(30))
+	c.Assert(client.UpdateChart(space.ID, *created), IsNil)
+	c.Assert(client.UpdateChart(space.ID, chart), HasLen, 1)
+
+	charts, errs := client.ListCharts(space.ID)
+
+	c.Assert(errs, IsNil)
+	c.Assert(charts, HasLen, 1)
+	c.Assert(charts[0].Max, Equals, 500.0)
+
+	space, errs = client.GetSpace(10)
+
+	c.Assert(errs, IsNil)
+	c.Assert(space.Charts, HasLen, 1)
+
+	list, errs := client.ListSpaces(ListSpacesOptions{})
+
+	c.Assert(errs, IsNil)
+	c.Assert(list.Spaces, HasLen, 1)
+
+	c.Assert(client.UpdateSpace(Space{ID: 10, Name: "API v2"}), IsNil)
+	c.Assert(client.DeleteChart(10, 20), IsNil)
+	c.Assert(client.DeleteSpace(10), IsNil)
+
+	c.Assert(api.changes(), DeepEquals, []string{
+		`POST /v1/spaces {"name":"API"}`,
+		`POST /v1/spaces/10/charts {"name":"Latency","type":"line","streams":[{"metric":"api.latency","tags":[{"name":"region","values":["eu"]}]}],"thresholds":[{"value":200,"color":"#ff0000"}]}`,
+		`PUT /v1/spaces/10/charts/20 {"id":20,"name":"Latency","type":"line","streams":[{"id":30,"metric":"api.latency","tags":[{"name":"region","values":["eu"]}]}]}`,
+		`PUT /v1/spaces/10 {"name":"API v2"}`,
+		`DELETE /v1/spaces/10/charts/20 `,
+		`DELETE /v1/spaces/10 `,
+	})
+
+	composite := Chart{
+		Name:    "Total",
+		Type:    CHART_TYPE_BIGNUMBER,
+		Streams: []*ChartStream{{Composite: Sum(S("api.req", "*"))}},
+	}
+
+	c.Assert(composite.Validate(), IsNil)
+
+	composite.Streams = append(composite.Streams, NewChartStream(Gauge{Name: "api.req"}))
+
+	c.Assert(composite.Validate(), NotNil)
+	c.Assert(Chart{Name: "A", Streams: []*ChartStream{{Metric: "a", Composite: "s(\"a\", \"*\")"}}}.Validate(), NotNil)
+	c.Assert(Chart{Name: "A", Type: "pie", Streams: []*ChartStream{{Metric: "a"}}}.Validate(), NotNil)
+	c.Assert(Chart{Name: "A"}.Validate(), NotNil)
+}
+
+func (s *LibratoSuite) TestReconcile(c *C) {
+	client, api := s.newAPIClient(apiRoutes{
+		"GET /v1/metrics/api.latency": replyWithStatus(http.StatusNotFound, ""),
+		"GET /v1/metrics/api.req":     reply(`{"name":"api.req","type":"gauge","display_name":"Requests","period":60}`),
+		"GET /v1/spaces":              reply(`{"query":{"found":1,"length":1,"offset":0,"total":1},"spaces":[{"id":10,"name":"app.API"},{"id":12,"name":"Billing"}]}`),
+		"GET /v1/spaces/10/charts":    reply(`[{"id":20,"name":"Latency","type":"line","streams":[{"id":30,"metric":"api.latency"}]},{"id":21,"name":"Old","type":"line","streams":[{"id":31,"metric":"old"}]}]`),
+		"GET /v1/alerts":              reply(`{"query":{"found":2,"length":2,"offset":0,"total":2},"alerts":[{"id":5,"name":"app.a1","conditions":[{"type":"above","metric_name":"api.latency","threshold":200}],"services":[{"id":17,"type":"slack"}],"active":true},{"id":6,"name":"billing","conditions":[{"type":"absent","metric_name":"payments","duration":600}],"services":[{"id":18,"type":"mail"}],"active":true}]}`),
+		"GET /v1/services":            reply(`{"query":{"found":2,"length":2,"offset":0,"total":2},"services":[{"id":17,"type":"slack","title":"app.Ops","settings":{"url":"https://hooks.slack.com/1"}},{"id":18,"type":"mail","title":"Billing","settings":{"addresses":"billing@domain.com"}}]}`),
+		"POST /v1/spaces":             replyWithStatus(http.StatusCreated, `{"id":11,"name":"app.Workers"}`),
+		"POST /v1/spaces/11/charts":   replyWithStatus(http.StatusCreated, `{"id":1}`),
+		"POST /v1/alerts":             replyWithStatus(http.StatusCreated, `{"id":1}`),
+		"POST /v1/services":           replyWithStatus(http.StatusCreated, `{"id":19,"type":"webhook","title":"app.Hook","settings":{"url":"https://domain.com/hook"}}`),
+	})
+
+	state, err := ParseState([]byte(`{
+		"metrics": [
+			{"name": "api.latency", "display_name": "Latency"},
+			{"name": "api.req", "display_name": "Requests"}
+		],
+		"spaces": [
+			{"name": "app.API", "charts": [
+				{"name": "Latency", "type": "stacked", "streams": [{"metric": "api.latency"}]}
+			]},
+			{"name": "app.Workers", "charts": [
+				{"name": "Jobs", "streams": [{"metric": "jobs"}]}
+			]}
+		],
+		"alerts": [
+			{"name": "app.a1", "conditions": [{"type": "above", "metric_name": "api.latency", "threshold": 200}], "services": [{"id": 17}], "active": true},
+			{"name": "app.a2", "conditions": [{"type": "absent", "metric_name": "jobs", "duration": 600}], "services": [{"title": "app.Ops"}], "active": true}
+		]
+	}`))
+
+	c.Assert(err, IsNil)
+
+	_, errs := client.Plan(*state, ReconcileOptions{Prune: true})
+
+	c.Assert(errs, HasLen, 1)
+
+	plan, errs := client.Plan(*state, ReconcileOptions{Prune: true, PrunePrefix: "app."})
+
+	c.Assert(errs, IsNil)
+	c.Assert(api.changes(), IsNil)
+	c.Assert(plan.String(), Equals, `+ metric api.latency
+~ chart app.API/Latency
+- chart app.API/Old
++ space app.Workers
++ chart app.Workers/Jobs
++ alert app.a2
+`)
+
+	c.Assert(plan.Apply(context.Background()), IsNil)
+	c.Assert(api.changes(), DeepEquals, []string{
+		`PUT /v1/metrics/api.latency {"name":"api.latency","display_name":"Latency"}`,
+		`PUT /v1/spaces/10/charts/20 {"id":20,"name":"Latency","type":"stacked","streams":[{"metric":"api.latency"}]}`,
+		`DELETE /v1/spaces/10/charts/21 `,
+		`POST /v1/spaces {"name":"app.Workers"}`,
+		`POST /v1/spaces/11/charts {"name":"Jobs","streams":[{"metric":"jobs"}]}`,
+		`POST /v1/alerts {"name":"app.a2","conditions":[{"type":"absent","metric_name":"jobs","threshold":0,"duration":600}],"services":[17],"active":true}`,
+	})
+
+	plan, errs = client.Plan(State{}, ReconcileOptions{})
+
+	c.Assert(errs, IsNil)
+	c.Assert(plan.IsEmpty(), Equals, true)
+	c.Assert(plan.String(), Equals, "No changes")
+
+	state, err = ParseState([]byte(`{
+		"alerts": [
+			{"name": "app.a1", "conditions": [{"type": "above", "metric_name": "api.latency", "threshold": 200}]}
+		]
+	}`))
+
+	c.Assert(err, IsNil)
+
+	plan, errs = client.Plan(*state, ReconcileOptions{})
+
+	c.Assert(errs, IsNil)
+	c.Assert(plan.IsEmpty(), Equals, true)
+
+	state, err = ParseState([]byte(`{
+		"services": [
+			{"type": "webhook", "title": "app.Hook", "settings": {"url": "https://domain.com/hook"}}
+		],
+		"spaces": [{"name": "app.Workers"}],
+		"alerts": [
+			{"name": "app.a2", "conditions": [{"type": "absent", "metric_name": "jobs", "duration": 600}], "services": [{"title": "app.Hook"}]}
+		]
+	}`))
+
+	c.Assert(err, IsNil)
+
+	plan, errs = client.Plan(*state, ReconcileOptions{Prune: true, PrunePrefix: "app."})
+
+	// Billing space, alert and service don't have prefix and must not be removed
+	c.Assert(errs, IsNil)
+	c.Assert(plan.String(), Equals, `+ service app.Hook
++ space app.Workers
+- space app.API
++ alert app.a2
+- alert app.a1
+- service app.Ops
+`)
+
+	api.reset()
+
+	c.Assert(plan.Apply(context.Background()), IsNil)
+	c.Assert(api.changes(), DeepEquals, []string{
+		`POST /v1/services {"type":"webhook","title":"app.Hook","settings":{"url":"https://domain.com/hook"}}`,
+		`POST /v1/spaces {"name":"app.Workers"}`,
+		`DELETE /v1/spaces/10 `,
+		`POST /v1/alerts {"name":"app.a2","conditions":[{"type":"absent","metric_name":"jobs","threshold":0,"duration":600}],"services":[19]}`,
+		`DELETE /v1/alerts/5 `,
+		`DELETE /v1/services/17 `,
+	})
+
+	_, errs = client.Plan(State{Spaces: []*Space{{Name: "A"}, {Name: "A"}}}, ReconcileOptions{})
+
+	c.Assert(errs, HasLen, 1)
+
+	_, errs = client.Plan(State{Alerts: []*Alert{{
+		Name:       "app.a3",
+		Conditions: []*AlertCondition{{Type: ALERT_CONDITION_ABSENT, MetricName: "jobs"}},
+		Services:   []*AlertService{{Title: "Unknown"}},
+	}}}, ReconcileOptions{})
+
+	c.Assert(errs, HasLen, 1)
+
+	_, err = ParseState([]byte(`{"metrics":`))
+
+	c.Assert(err, NotNil)
+}
+
+func (s *LibratoSuite) TestSnapshots(c *C) {
+	var polls int32
+	var serverURL string
+
+	client, api := s.newAPIClient(apiRoutes{
+		"POST /v1/snapshots": func(r *http.Request) (int, string) {
+			return http.StatusAccepted, `{"href":"` + serverURL + `/v1/snapshots/7","job_href":"` + serverURL + `/v1/jobs/1","image_href":null,"duration":3600,"end_time":"2022-01-01T10:00:00Z"}`
+		},
+		"GET /v1/snapshots/7": func(r *http.Request) (int, string) {
+			if atomic.AddInt32(&polls, 1) < 3 {
+				return http.StatusOK, `{"href":"` + serverURL + `/v1/snapshots/7","image_href":null}`
+			}
+
+			return http.StatusOK, `{"href":"` + serverURL + `/v1/snapshots/7","image_href":"` + serverURL + `/images/7.png"}`
+		},
+		"GET /images/7.png": reply("PNG"),
+	})
+
+	serverURL = client.APIEndpoint
+
+	waitOpts := WaitSnapshotOptions{PollInterval: 10 * time.Millisecond}
+
+	_, errs := client.CreateSnapshot(SnapshotOptions{})
+	c.Assert(errs, HasLen, 1)
+	_, errs = client.CreateSnapshot(SnapshotOptions{ChartID: 20, SpaceID: 3})
+	c.Assert(errs, HasLen, 1)
+	_, errs = client.CreateSnapshot(SnapshotOptions{SpaceID: 3, ChartType: CHART_TYPE_LINE})
+	c.Assert(errs, HasLen, 1)
+
+	_, errs = client.CreateSnapshot(SnapshotOptions{SpaceID: 3, Duration: 600})
+
+	c.Assert(errs, IsNil)
+	c.Assert(api.changes(), DeepEquals, []string{
+		`POST /v1/snapshots {"subject":{"space_id":3},"duration":600}`,
+	})
+
+	api.reset()
+
+	snapshot, errs := client.CreateSnapshot(SnapshotOptions{ChartID: 20, Duration: 3600, EndTime: 1641031200})
+
+	c.Assert(errs, IsNil)
+	c.Assert(api.changes(), DeepEquals, []string{
+		`POST /v1/snapshots {"subject":{"chart":{"id":20}},"duration":3600,"end_time":1641031200}`,
+	})
+	c.Assert(snapshot.ID, Equals, int64(7))
+	c.Assert(snapshot.EndTime.Unix(), Equals, int64(1641031200))
+
+	_, errs = client.DownloadSnapshot(snapshot)
+	c.Assert(errs, HasLen, 1)
+
+	snapshot, errs = client.WaitSnapshot(snapshot.ID, waitOpts)
+
+	c.Assert(errs, IsNil)
+	c.Assert(atomic.LoadInt32(&polls), Equals, int32(3))
+	c.Assert(snapshot.ImageHref, Equals, serverURL+"/images/7.png")
+
+	image, errs := client.DownloadSnapshot(snapshot)
+
+	c.Assert(errs, IsNil)
+	c.Assert(string(image), Equals, "PNG")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	atomic.StoreInt32(&polls, -1000)
+	_, errs = client.WaitSnapshotContext(ctx, 7, waitOpts)
+
+	c.Assert(errs, HasLen, 1)
+
+	waitOpts.Timeout = 30 * time.Millisecond
+	_, errs = client.WaitSnapshot(7, waitOpts)
+
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errs[0], Equals, context.DeadlineExceeded)
+}
+
+func (s *LibratoSuite) TestSources(c *C) {
+	client, api := s.newAPIClient(apiRoutes{
+		"GET /v1/sources": func(r *http.Request) (int, string) {
+			if r.URL.Query().Get("offset") == "" {
+				return http.StatusOK, `{"query":{"found":3,"length":2,"offset":0,"total":3},"sources":[{"name":"web1"},{"name":"web2"}]}`
+			}
+
+			return http.StatusOK, `{"query":{"found":4,"length":2,"offset":2,"total":4},"sources":[{"name":"web3","display_name":"Web 3"},{"name":"web4"}]}`
+		},
+		"GET /v1/sources/web3": reply(`{"name":"web3","display_name":"Web 3"}`),
+		"GET /v1/metrics/cpu": func(r *http.Request) (int, string) {
+			if r.URL.Query().Get("start_time") == "" {
+				return http.StatusOK, `{"resolution":86400,"measurements":{"web1":[{"measure_time":100,"value":1}],"web2":[]},"query":{"next_time":200}}`
+			}
+
+			return http.StatusOK, `{"resolution":86400,"measurements":{"web3":[{"measure_time":200,"value":1}]}}`
+		},
+		"GET /v1/metrics/mem":  reply(`{"resolution":86400,"measurements":{"web1":[{"measure_time":100,"value":1}]}}`),
+		"GET /v1/metrics/disk": reply(`{"resolution":86400,"measurements":{"web2":[{"measure_time":100,"value":1}]}}`),
+		"GET /v1/metrics": func(r *http.Request) (int, string) {
+			if r.URL.Query().Get("offset") == "" {
+				return http.StatusOK, `{"query":{"found":4,"length":2,"offset":0,"total":4},"metrics":[{"name":"cpu","type":"gauge"},{"name":"cpu_total","type":"composite"}]}`
+			}
+
+			return http.StatusOK, `{"query":{"found":4,"length":2,"offset":2,"total":4},"metrics":[{"name":"mem","type":"gauge"},{"name":"disk","type":"gauge"}]}`
+		},
+	})
+
+	source, errs := client.GetSource("web3")
+
+	c.Assert(errs, IsNil)
+	c.Assert(source.DisplayName, Equals, "Web 3")
+
+	c.Assert(client.UpdateSource(Source{Name: "web1", DisplayName: "Web 1"}), IsNil)
+	c.Assert(client.UpdateSource(Source{DisplayName: "Web 1"}), HasLen, 1)
+	c.Assert(client.DeleteSource("web2"), IsNil)
+	c.Assert(client.DeleteSource(""), HasLen, 1)
+
+	c.Assert(api.changes(), DeepEquals, []string{
+		`PUT /v1/sources/web1 {"display_name":"Web 1"}`,
+		`DELETE /v1/sources/web2 `,
+	})
+
+	list, errs := client.ListSources(ListSourcesOptions{Name: "web"})
+
+	c.Assert(errs, IsNil)
+	c.Assert(list.Sources, HasLen, 2)
+	c.Assert(list.Query.HasMore(), Equals, true)
+
+	// web2 reports only disk metric and web4 doesn't report anything
+	inactive, errs := client.ListInactiveSources(7)
+
+	c.Assert(errs, IsNil)
+	c.Assert(inactive, DeepEquals, []*Source{{Name: "web4"}})
+
+	_, errs = client.ListInactiveSources(0)
+	c.Assert(errs, HasLen, 1)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// newAPIClient creates client connected to fake API which responds using given
+// routes ("METHOD /path") and records all requests
+func (s *LibratoSuite) newAPIClient(routes apiRoutes) (*Client, *fakeAPI) {
+	api := &fakeAPI{routes: routes}
+	server := httptest.NewServer(api)
+
+	client := NewClient("test@domain.com", "abcd1234")
+	client.APIEndpoint = server.URL
+
+	s.servers = append(s.servers, server)
+
+	return client, api
+}
+
+func (s *LibratoSuite) newClient() *Client {
+	client := NewClient("test@domain.com", "abcd1234")
+	client.APIEndpoint = s.server.URL
+
+	return client
+}
+
+func (s *LibratoSuite) handler(w http.ResponseWriter, r *http.Request) {
+	data := measurements{}
+
+	if json.NewDecoder(r.Body).Decode(&data) != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	atomic.AddInt64(&s.received, int64(len(data.Gauges)+len(data.Counters)))
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// apiRoute returns status code and body of response to request
+type apiRoute func(r *http.Request) (int, string)
+
+// apiRoutes is map "METHOD /path" → route
+type apiRoutes map[string]apiRoute
+
+// fakeAPI is fake Librato API which records all received requests with compacted
+// JSON bodies. Requests without route get 204 No Content.
+type fakeAPI struct {
+	routes apiRoutes
+	log    []string
+	last   *http.Request
+	mu     sync.Mutex
+}
+
+// ServeHTTP records request and writes response
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	compacted := &bytes.Buffer{}
+
+	if json.Compact(compacted, body) == nil {
+		body = compacted.Bytes()
+	}
+
+	a.mu.Lock()
+	a.log = append(a.log, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+	a.last = r
+	a.mu.Unlock()
+
+	route := a.routes[r.Method+" "+r.URL.Path]
+
+	if route == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	status, data := route(r)
+
+	w.WriteHeader(status)
+	w.Write([]byte(data))
+}
+
+// requests returns all recorded requests ("METHOD URI body")
+func (a *fakeAPI) requests() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]string(nil), a.log...)
+}
+
+// changes returns all recorded requests except GET requests
+func (a *fakeAPI) changes() []string {
+	var result []string
+
+	for _, r := range a.requests() {
+		if !strings.HasPrefix(r, http.MethodGet+" ") {
+			result = append(result, r)
+		}
+	}
+
+	return result
+}
+
+// lastRequest returns the latest recorded request
+func (a *fakeAPI) lastRequest() *http.Request {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.last
+}
+
+// reset removes all recorded requests
+func (a *fakeAPI) reset() {
+	a.mu.Lock()
+	a.log, a.last = nil, nil
+	a.mu.Unlock()
+}
+
+// reply returns route which responds with 200 OK and given body
+func reply(body string) apiRoute {
+	return replyWithStatus(http.StatusOK, body)
+}
+
+// replyWithStatus returns route which responds with given status and body
+func replyWithStatus(status int, body string) apiRoute {
+	return func(r *http.Request) (int, string) {
+		return status, body
+	}
+}
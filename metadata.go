@@ -0,0 +1,318 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+
+	"github.com/essentialkaos/ek/v12/req"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Metric types
+const (
+	METRIC_TYPE_GAUGE     = "gauge"
+	METRIC_TYPE_COUNTER   = "counter"
+	METRIC_TYPE_COMPOSITE = "composite"
+)
+
+// Summarize functions
+const (
+	SUMMARIZE_AVERAGE = "average"
+	SUMMARIZE_SUM     = "sum"
+	SUMMARIZE_COUNT   = "count"
+	SUMMARIZE_MIN     = "min"
+	SUMMARIZE_MAX     = "max"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Metric contains metric metadata
+type Metric struct {
+	// Name of the metric
+	Name string `json:"name"`
+
+	// Type of the metric (gauge, counter or composite)
+	Type string `json:"type,omitempty"`
+
+	// Name which will be used for the metric when viewing the Metrics website
+	DisplayName string `json:"display_name,omitempty"`
+
+	// Text that can be used to explain precisely what the metric is measuring
+	Description string `json:"description,omitempty"`
+
+	// Number of seconds that is the standard reporting period of the metric
+	Period int `json:"period,omitempty"`
+
+	// Composite metric definition (only for composite metrics)
+	Composite string `json:"composite,omitempty"`
+
+	// Metric attributes
+	Attributes *MetricAttributes `json:"attributes,omitempty"`
+}
+
+// MetricAttributes contains metric attributes which affect how metric is displayed
+type MetricAttributes struct {
+	// Color used for the metric in charts (#RRGGBB)
+	Color string `json:"color,omitempty"`
+
+	// Minimum and maximum values of the Y-axis in charts
+	DisplayMin interface{} `json:"display_min,omitempty"`
+	DisplayMax interface{} `json:"display_max,omitempty"`
+
+	// Long and short names of measurement units
+	DisplayUnitsLong  string `json:"display_units_long,omitempty"`
+	DisplayUnitsShort string `json:"display_units_short,omitempty"`
+
+	// If true, the metric will be displayed as stacked chart (if nil, current
+	// value will be kept)
+	DisplayStacked *bool `json:"display_stacked,omitempty"`
+
+	// Function used for summarizing data over time (average, sum, count, min or max)
+	SummarizeFunction string `json:"summarize_function,omitempty"`
+
+	// If true, the metric will be aggregated to the period of the metric (if nil,
+	// current value will be kept)
+	Aggregate *bool `json:"aggregate,omitempty"`
+
+	// User-Agent of the client which created the metric
+	CreatedByUA string `json:"created_by_ua,omitempty"`
+
+	// If true, gaps in the data will be detected (if nil, current value will
+	// be kept)
+	GapDetection *bool `json:"gap_detection,omitempty"`
+}
+
+// ListMetricsOptions contains options of metrics listing
+type ListMetricsOptions struct {
+	Pagination
+
+	// Name is search string used for filtering metrics by name
+	Name string
+}
+
+// MetricsList contains page of metrics list
+type MetricsList struct {
+	Query   QueryInfo `json:"query"`
+	Metrics []*Metric `json:"metrics"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type metricNames struct {
+	Names []string `json:"names"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var colorRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// GetMetric synchronously fetches metric metadata from librato
+func GetMetric(name string) (*Metric, []error) {
+	return defaultClient.GetMetric(name)
+}
+
+// GetMetricContext synchronously fetches metric metadata from librato with
+// given context
+func GetMetricContext(ctx context.Context, name string) (*Metric, []error) {
+	return defaultClient.GetMetricContext(ctx, name)
+}
+
+// UpdateMetric synchronously creates or updates metric metadata on librato
+func UpdateMetric(m Metric) []error {
+	return defaultClient.UpdateMetric(m)
+}
+
+// UpdateMetricContext synchronously creates or updates metric metadata on librato
+// with given context
+func UpdateMetricContext(ctx context.Context, m Metric) []error {
+	return defaultClient.UpdateMetricContext(ctx, m)
+}
+
+// ListMetrics synchronously fetches list of metrics from librato
+func ListMetrics(opts ListMetricsOptions) (*MetricsList, []error) {
+	return defaultClient.ListMetrics(opts)
+}
+
+// ListMetricsContext synchronously fetches list of metrics from librato with
+// given context
+func ListMetricsContext(ctx context.Context, opts ListMetricsOptions) (*MetricsList, []error) {
+	return defaultClient.ListMetricsContext(ctx, opts)
+}
+
+// DeleteMetrics synchronously removes metrics with all measurements on librato
+func DeleteMetrics(names ...string) []error {
+	return defaultClient.DeleteMetrics(names...)
+}
+
+// DeleteMetricsContext synchronously removes metrics with all measurements on
+// librato with given context
+func DeleteMetricsContext(ctx context.Context, names ...string) []error {
+	return defaultClient.DeleteMetricsContext(ctx, names...)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// GetMetric synchronously fetches metric metadata from librato
+func (c *Client) GetMetric(name string) (*Metric, []error) {
+	return c.GetMetricContext(context.Background(), name)
+}
+
+// GetMetricContext synchronously fetches metric metadata from librato with
+// given context
+func (c *Client) GetMetricContext(ctx context.Context, name string) (*Metric, []error) {
+	if name == "" {
+		return nil, errEmptyMetricName
+	}
+
+	result := &Metric{}
+	errs := c.execJSONRequest(ctx, req.GET, "/v1/metrics/"+url.PathEscape(name), nil, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// UpdateMetric synchronously creates or updates metric metadata on librato
+func (c *Client) UpdateMetric(m Metric) []error {
+	return c.UpdateMetricContext(context.Background(), m)
+}
+
+// UpdateMetricContext synchronously creates or updates metric metadata on librato
+// with given context
+func (c *Client) UpdateMetricContext(ctx context.Context, m Metric) []error {
+	err := m.Validate()
+
+	if err != nil {
+		return []error{err}
+	}
+
+	return c.execJSONRequest(ctx, req.PUT, "/v1/metrics/"+url.PathEscape(m.Name), nil, m, nil)
+}
+
+// ListMetrics synchronously fetches list of metrics from librato
+func (c *Client) ListMetrics(opts ListMetricsOptions) (*MetricsList, []error) {
+	return c.ListMetricsContext(context.Background(), opts)
+}
+
+// ListMetricsContext synchronously fetches list of metrics from librato with
+// given context
+func (c *Client) ListMetricsContext(ctx context.Context, opts ListMetricsOptions) (*MetricsList, []error) {
+	query := url.Values{}
+
+	opts.Pagination.apply(query)
+
+	if opts.Name != "" {
+		query.Set("name", opts.Name)
+	}
+
+	result := &MetricsList{}
+	errs := c.execJSONRequest(ctx, req.GET, "/v1/metrics", query, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// DeleteMetrics synchronously removes metrics with all measurements on librato
+func (c *Client) DeleteMetrics(names ...string) []error {
+	return c.DeleteMetricsContext(context.Background(), names...)
+}
+
+// DeleteMetricsContext synchronously removes metrics with all measurements on
+// librato with given context
+func (c *Client) DeleteMetricsContext(ctx context.Context, names ...string) []error {
+	if len(names) == 0 {
+		return errEmptyMetricName
+	}
+
+	for _, name := range names {
+		if name == "" {
+			return errEmptyMetricName
+		}
+	}
+
+	return c.execJSONRequest(ctx, req.DELETE, "/v1/metrics", nil, metricNames{names}, nil)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Validate validates metric struct
+func (m Metric) Validate() error {
+	return validateMetric(m)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// validateMetric validate metric struct
+func validateMetric(m Metric) error {
+	if m.Name == "" {
+		return errors.New("Metric property Name can't be empty")
+	}
+
+	if len(m.Name) > 255 {
+		return errors.New("Length of metric property Name must be 255 or fewer characters")
+	}
+
+	switch m.Type {
+	case "", METRIC_TYPE_GAUGE, METRIC_TYPE_COUNTER:
+		if m.Composite != "" {
+			return errors.New("Metric property Composite can be set only for composite metrics")
+		}
+	case METRIC_TYPE_COMPOSITE:
+		if m.Composite == "" {
+			return errors.New("Metric property Composite can't be empty for composite metrics")
+		}
+	default:
+		return errors.New("Metric property Type contains unsupported metric type")
+	}
+
+	if m.Period < 0 {
+		return errors.New("Metric property Period can't be negative")
+	}
+
+	if m.Attributes == nil {
+		return nil
+	}
+
+	return validateMetricAttributes(m.Attributes)
+}
+
+// validateMetricAttributes validate metric attributes struct
+func validateMetricAttributes(a *MetricAttributes) error {
+	if a.Color != "" && !colorRegexp.MatchString(a.Color) {
+		return errors.New("Metric attribute Color must be in #RRGGBB format")
+	}
+
+	if !isNumericOrNil(a.DisplayMin) {
+		return errors.New("Metric attribute DisplayMin can't be non-numeric")
+	}
+
+	if !isNumericOrNil(a.DisplayMax) {
+		return errors.New("Metric attribute DisplayMax can't be non-numeric")
+	}
+
+	switch a.SummarizeFunction {
+	case "", SUMMARIZE_AVERAGE, SUMMARIZE_SUM, SUMMARIZE_COUNT,
+		SUMMARIZE_MIN, SUMMARIZE_MAX:
+	default:
+		return errors.New("Metric attribute SummarizeFunction contains unsupported function")
+	}
+
+	return nil
+}
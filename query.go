@@ -0,0 +1,320 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/essentialkaos/ek/v12/req"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// QueryOptions contains options of measurements query
+type QueryOptions struct {
+	// Unix timestamp of the beginning of the time range
+	StartTime int64
+
+	// Unix timestamp of the end of the time range (current time by default)
+	EndTime int64
+
+	// Duration of the time range in seconds (can be used instead of StartTime)
+	Duration int64
+
+	// Resolution of the data in seconds (required for tagged measurements)
+	Resolution int
+
+	// Time period in seconds used for summarizing data (only for source-based
+	// measurements)
+	SummarizeTime int
+
+	// List of sources used for filtering data. If set, source-based
+	// measurements API will be used.
+	Sources []string
+
+	// If true, data from all sources will be summarized into one series (only
+	// for source-based measurements)
+	SummarizeSources bool
+
+	// Tags used for filtering data (only for tagged measurements)
+	Tags Tags
+
+	// Search query used for filtering data by tags (only for tagged measurements)
+	TagsSearch string
+
+	// Name of tag used for grouping series on the server (only for tagged
+	// measurements)
+	GroupBy string
+
+	// Function used for grouping series (sum, min, max, mean)
+	GroupByFunction string
+
+	// Function used for summarizing data (sum, min, max, mean, count)
+	SummaryFunction string
+
+	// UseSources enables source-based measurements API without sources filter
+	UseSources bool
+}
+
+// QueryResult contains result of measurements query
+type QueryResult struct {
+	// Name of the metric
+	Name string
+
+	// Resolution of the data in seconds
+	Resolution int
+
+	// List of data series
+	Series []*Series
+
+	// Unix timestamp which should be used as StartTime for fetching the next
+	// page of data (0 if there are no more data)
+	NextTime int64
+}
+
+// Series contains data of one source or tag set
+type Series struct {
+	Metric string  `json:"-"` // Name of metric (only for composite queries)
+	Source string  `json:"source,omitempty"`
+	Tags   Tags    `json:"tags,omitempty"`
+	Points []Point `json:"measurements"`
+}
+
+// Point contains single measurement value or summary of measurements
+type Point struct {
+	Time       int64   `json:"time"`
+	Value      float64 `json:"value"`
+	Count      float64 `json:"count,omitempty"`
+	Sum        float64 `json:"sum,omitempty"`
+	Min        float64 `json:"min,omitempty"`
+	Max        float64 `json:"max,omitempty"`
+	SumSquares float64 `json:"sum_squares,omitempty"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type queryPageInfo struct {
+	NextTime int64 `json:"next_time"`
+}
+
+type taggedQueryResponse struct {
+	Name       string        `json:"name"`
+	Resolution int           `json:"resolution"`
+	Series     []*Series     `json:"series"`
+	Query      queryPageInfo `json:"query"`
+}
+
+type sourcesQueryResponse struct {
+	Resolution   int                       `json:"resolution"`
+	Measurements map[string][]sourcesPoint `json:"measurements"`
+	Query        queryPageInfo             `json:"query"`
+}
+
+type sourcesPoint struct {
+	Point
+	MeasureTime int64 `json:"measure_time"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// QueryMeasurements synchronously fetches measurements of metric from librato
+func QueryMeasurements(name string, opts QueryOptions) (*QueryResult, []error) {
+	return defaultClient.QueryMeasurements(name, opts)
+}
+
+// QueryMeasurementsContext synchronously fetches measurements of metric from
+// librato with given context
+func QueryMeasurementsContext(ctx context.Context, name string, opts QueryOptions) (*QueryResult, []error) {
+	return defaultClient.QueryMeasurementsContext(ctx, name, opts)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// QueryMeasurements synchronously fetches measurements of metric from librato
+func (c *Client) QueryMeasurements(name string, opts QueryOptions) (*QueryResult, []error) {
+	return c.QueryMeasurementsContext(context.Background(), name, opts)
+}
+
+// QueryMeasurementsContext synchronously fetches measurements of metric from
+// librato with given context
+func (c *Client) QueryMeasurementsContext(ctx context.Context, name string, opts QueryOptions) (*QueryResult, []error) {
+	if name == "" {
+		return nil, errEmptyMetricName
+	}
+
+	err := opts.Validate()
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	if opts.isSourceBased() {
+		return c.querySourceMeasurements(ctx, name, opts)
+	}
+
+	return c.queryTaggedMeasurements(ctx, name, opts)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Next returns query options for fetching the next page of data
+func (o QueryOptions) Next(r *QueryResult) QueryOptions {
+	o.StartTime, o.Duration = r.NextTime, 0
+	return o
+}
+
+// Validate validates query options
+func (o QueryOptions) Validate() error {
+	return validateQueryOptions(o)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// queryTaggedMeasurements fetches tagged measurements
+func (c *Client) queryTaggedMeasurements(ctx context.Context, name string, opts QueryOptions) (*QueryResult, []error) {
+	resp := &taggedQueryResponse{}
+	errs := c.execJSONRequest(
+		ctx, req.GET, "/v1/measurements/"+url.PathEscape(name),
+		opts.toQuery(), nil, resp,
+	)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	if resp.Name == "" {
+		resp.Name = name
+	}
+
+	return &QueryResult{
+		Name:       resp.Name,
+		Resolution: resp.Resolution,
+		Series:     resp.Series,
+		NextTime:   resp.Query.NextTime,
+	}, nil
+}
+
+// querySourceMeasurements fetches source-based measurements
+func (c *Client) querySourceMeasurements(ctx context.Context, name string, opts QueryOptions) (*QueryResult, []error) {
+	resp := &sourcesQueryResponse{}
+	errs := c.execJSONRequest(
+		ctx, req.GET, "/v1/metrics/"+url.PathEscape(name),
+		opts.toQuery(), nil, resp,
+	)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	result := &QueryResult{
+		Name:       name,
+		Resolution: resp.Resolution,
+		NextTime:   resp.Query.NextTime,
+	}
+
+	sources := make([]string, 0, len(resp.Measurements))
+
+	for source := range resp.Measurements {
+		sources = append(sources, source)
+	}
+
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		series := &Series{Source: source}
+
+		for _, p := range resp.Measurements[source] {
+			p.Point.Time = p.MeasureTime
+			series.Points = append(series.Points, p.Point)
+		}
+
+		result.Series = append(result.Series, series)
+	}
+
+	return result, nil
+}
+
+// isSourceBased returns true if source-based measurements API must be used
+func (o QueryOptions) isSourceBased() bool {
+	return o.UseSources || len(o.Sources) != 0 || o.SummarizeSources || o.SummarizeTime != 0
+}
+
+// toQuery converts options to request query
+func (o QueryOptions) toQuery() url.Values {
+	query := url.Values{}
+
+	setQueryInt(query, "start_time", o.StartTime)
+	setQueryInt(query, "end_time", o.EndTime)
+	setQueryInt(query, "duration", o.Duration)
+	setQueryInt(query, "resolution", int64(o.Resolution))
+	setQueryInt(query, "summarize_time", int64(o.SummarizeTime))
+
+	for _, source := range o.Sources {
+		query.Add("sources[]", source)
+	}
+
+	if o.SummarizeSources {
+		query.Set("summarize_sources", "true")
+	}
+
+	for name, value := range o.Tags {
+		query.Set("tags["+name+"]", value)
+	}
+
+	setQueryString(query, "tags_search", o.TagsSearch)
+	setQueryString(query, "group_by", o.GroupBy)
+	setQueryString(query, "group_by_function", o.GroupByFunction)
+	setQueryString(query, "summary_function", o.SummaryFunction)
+
+	return query
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// validateQueryOptions validate query options struct
+func validateQueryOptions(o QueryOptions) error {
+	if o.StartTime <= 0 && o.Duration <= 0 {
+		return errors.New("Query property StartTime or Duration must be set")
+	}
+
+	if o.EndTime != 0 && o.EndTime < o.StartTime {
+		return errors.New("Query property EndTime can't be less than StartTime")
+	}
+
+	if o.isSourceBased() {
+		if len(o.Tags) != 0 || o.TagsSearch != "" || o.GroupBy != "" {
+			return errors.New("Tags filtering and grouping can't be used with source-based queries")
+		}
+
+		return nil
+	}
+
+	if o.Resolution <= 0 {
+		return errors.New("Query property Resolution must be set for tagged measurements")
+	}
+
+	return validateTags(o.Tags)
+}
+
+// setQueryInt adds numeric value to query if it greater than zero
+func setQueryInt(query url.Values, name string, value int64) {
+	if value > 0 {
+		query.Set(name, strconv.FormatInt(value, 10))
+	}
+}
+
+// setQueryString adds string value to query if it isn't empty
+func setQueryString(query url.Values, name, value string) {
+	if value != "" {
+		query.Set(name, value)
+	}
+}
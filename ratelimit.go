@@ -0,0 +1,202 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// RateLimit contains info about API rate limit
+type RateLimit struct {
+	Limit     int       // Maximum number of requests
+	Remaining int       // Number of remaining requests
+	Reset     time.Time // Date when limit will be reset
+}
+
+// RateLimits contains info about all API rate limits
+type RateLimits struct {
+	Std RateLimit // Limit for token and endpoint
+	Agg RateLimit // Limit for the whole account
+}
+
+// RATE_LIMIT_RESERVE is fraction of rate limit which is kept in reserve. If
+// number of remaining requests is less than reserve, requests are spread evenly
+// until limit reset.
+const RATE_LIMIT_RESERVE = 0.1
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// GetRateLimits returns info about API rate limits from the latest response
+// to package-level methods
+func GetRateLimits() RateLimits {
+	return defaultClient.RateLimits()
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// RateLimits returns info about API rate limits from the latest response
+func (c *Client) RateLimits() RateLimits {
+	c.rateLimitsMu.RLock()
+	defer c.rateLimitsMu.RUnlock()
+
+	return c.rateLimits
+}
+
+// IsEmpty returns true if rate limit info is empty
+func (l RateLimit) IsEmpty() bool {
+	return l.Limit == 0 && l.Remaining == 0 && l.Reset.IsZero()
+}
+
+// IsExceeded returns true if there are no remaining requests
+func (l RateLimit) IsExceeded() bool {
+	return !l.IsEmpty() && l.Remaining <= 0 && time.Now().Before(l.Reset)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// updateRateLimits updates rate limits info using response headers
+func (c *Client) updateRateLimits(header http.Header) {
+	std := parseRateLimit(header.Get("X-Librato-RateLimit-Std"))
+	agg := parseRateLimit(header.Get("X-Librato-RateLimit-Agg"))
+
+	if std.IsEmpty() && agg.IsEmpty() {
+		return
+	}
+
+	c.rateLimitsMu.Lock()
+
+	if !std.IsEmpty() {
+		c.rateLimits.Std = std
+	}
+
+	if !agg.IsEmpty() {
+		c.rateLimits.Agg = agg
+	}
+
+	limits := c.rateLimits
+
+	c.rateLimitsMu.Unlock()
+
+	handler := c.getRateLimitHandler()
+
+	if handler != nil {
+		handler(limits)
+	}
+}
+
+// waitRateLimit paces requests if number of remaining requests is less than
+// reserve and waits until rate limit reset if there are no remaining requests
+func (c *Client) waitRateLimit(ctx context.Context) error {
+	c.rateLimitsMu.Lock()
+
+	delay := maxDuration(c.rateLimits.Std.getDelay(), c.rateLimits.Agg.getDelay())
+
+	// Every request consumes one of remaining requests until we get actual
+	// info from API
+	c.rateLimits.Std.consume()
+	c.rateLimits.Agg.consume()
+
+	c.rateLimitsMu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// getDelay returns delay before next request
+func (l RateLimit) getDelay() time.Duration {
+	if l.IsEmpty() || l.Limit <= 0 {
+		return 0
+	}
+
+	untilReset := time.Until(l.Reset)
+
+	if untilReset <= 0 {
+		return 0
+	}
+
+	reserve := int(float64(l.Limit) * RATE_LIMIT_RESERVE)
+
+	switch {
+	case l.Remaining <= 0:
+		return untilReset
+	case l.Remaining > reserve:
+		return 0
+	}
+
+	return untilReset / time.Duration(l.Remaining+1)
+}
+
+// consume decreases number of remaining requests
+func (l *RateLimit) consume() {
+	if !l.IsEmpty() && l.Remaining > 0 {
+		l.Remaining--
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// maxDuration returns the longest of given durations
+func maxDuration(d1, d2 time.Duration) time.Duration {
+	if d1 > d2 {
+		return d1
+	}
+
+	return d2
+}
+
+// parseRateLimit parses rate limit header value (limit=300,remaining=299,reset=1400000000)
+func parseRateLimit(data string) RateLimit {
+	var result RateLimit
+
+	if data == "" {
+		return result
+	}
+
+	for _, field := range strings.Split(data, ",") {
+		index := strings.Index(field, "=")
+
+		if index == -1 {
+			continue
+		}
+
+		value, err := strconv.ParseInt(strings.TrimSpace(field[index+1:]), 10, 64)
+
+		if err != nil {
+			continue
+		}
+
+		switch strings.TrimSpace(field[:index]) {
+		case "limit":
+			result.Limit = int(value)
+		case "remaining":
+			result.Remaining = int(value)
+		case "reset":
+			result.Reset = time.Unix(value, 0)
+		}
+	}
+
+	return result
+}
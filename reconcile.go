@@ -0,0 +1,894 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Reconciliation actions
+const (
+	ACTION_CREATE = "create"
+	ACTION_UPDATE = "update"
+	ACTION_DELETE = "delete"
+)
+
+// Kinds of reconciled objects
+const (
+	KIND_METRIC  = "metric"
+	KIND_SPACE   = "space"
+	KIND_CHART   = "chart"
+	KIND_ALERT   = "alert"
+	KIND_SERVICE = "service"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// State contains desired state of metrics metadata, notification services,
+// spaces with charts and alerts. Objects are matched with existing ones by
+// name (services are matched by title). Alerts can reference services by title
+// instead of ID (e.g. {"title": "Ops"}), such references are resolved while
+// applying the plan, so alerts can use services created by the same plan.
+type State struct {
+	Metrics  []*Metric  `json:"metrics,omitempty"`
+	Services []*Service `json:"services,omitempty"`
+	Spaces   []*Space   `json:"spaces,omitempty"`
+	Alerts   []*Alert   `json:"alerts,omitempty"`
+}
+
+// ReconcileOptions contains options of reconciliation
+type ReconcileOptions struct {
+	// If true, objects which are absent in desired state will be removed. Only
+	// kinds of objects listed in desired state are pruned (e.g. alerts are not
+	// touched if state doesn't contain alerts), charts are pruned only in spaces
+	// defined in desired state. Services used by alerts from desired state and
+	// metrics are never removed.
+	Prune bool
+
+	// Prefix of names (titles for services) of objects which can be removed
+	// while pruning. Objects managed outside of desired state are never touched
+	// if they don't have this prefix. Prefix is required if Prune is true.
+	PrunePrefix string
+}
+
+// ReconcilePlan contains list of changes required to bring Librato to desired state
+type ReconcilePlan struct {
+	Changes []*ReconcileChange
+
+	client *Client
+}
+
+// ReconcileChange contains info about single change
+type ReconcileChange struct {
+	Action string // Action (create/update/delete)
+	Kind   string // Kind of object (metric/service/space/chart/alert)
+	Name   string // Name of object (charts are named as space/chart)
+
+	apply func(ctx context.Context) []error
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// spaceRef contains ID of space which may be created during apply
+type spaceRef struct {
+	id int64
+}
+
+// serviceRef contains ID of notification service which may be created during apply
+type serviceRef struct {
+	id int64
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ReadState reads desired state from JSON file (other formats, e.g. YAML, must
+// be converted to JSON before reading)
+func ReadState(file string) (*State, error) {
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseState(data)
+}
+
+// ParseState parses desired state from JSON data
+func ParseState(data []byte) (*State, error) {
+	state := &State{}
+	err := json.Unmarshal(data, state)
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't parse state: %w", err)
+	}
+
+	return state, nil
+}
+
+// Plan synchronously reads current state from librato and returns list of changes
+// required to bring it to desired state
+func Plan(desired State, opts ReconcileOptions) (*ReconcilePlan, []error) {
+	return defaultClient.Plan(desired, opts)
+}
+
+// PlanContext synchronously reads current state from librato and returns list of
+// changes required to bring it to desired state with given context
+func PlanContext(ctx context.Context, desired State, opts ReconcileOptions) (*ReconcilePlan, []error) {
+	return defaultClient.PlanContext(ctx, desired, opts)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Plan synchronously reads current state from librato and returns list of changes
+// required to bring it to desired state
+func (c *Client) Plan(desired State, opts ReconcileOptions) (*ReconcilePlan, []error) {
+	return c.PlanContext(context.Background(), desired, opts)
+}
+
+// PlanContext synchronously reads current state from librato and returns list of
+// changes required to bring it to desired state with given context
+func (c *Client) PlanContext(ctx context.Context, desired State, opts ReconcileOptions) (*ReconcilePlan, []error) {
+	err := desired.Validate()
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	if opts.Prune && opts.PrunePrefix == "" {
+		return nil, []error{errors.New("Reconcile option PrunePrefix can't be empty if Prune is enabled")}
+	}
+
+	plan := &ReconcilePlan{client: c}
+
+	errs := c.planMetrics(ctx, plan, desired.Metrics)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	services, refs, errs := c.planServices(ctx, plan, desired)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	errs = c.planSpaces(ctx, plan, desired.Spaces, opts)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	errs = c.planAlerts(ctx, plan, desired.Alerts, refs, opts)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	// Services are removed last because removed alerts may use them
+	if opts.Prune {
+		c.pruneServices(plan, desired, services, opts)
+	}
+
+	return plan, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Validate validates desired state
+func (s State) Validate() error {
+	return validateState(s)
+}
+
+// IsEmpty returns true if plan doesn't contain any changes
+func (p *ReconcilePlan) IsEmpty() bool {
+	return p == nil || len(p.Changes) == 0
+}
+
+// String returns human-readable list of changes
+func (p *ReconcilePlan) String() string {
+	if p.IsEmpty() {
+		return "No changes"
+	}
+
+	var buf strings.Builder
+
+	for _, change := range p.Changes {
+		buf.WriteString(change.String() + "\n")
+	}
+
+	return buf.String()
+}
+
+// Apply synchronously applies all changes in order. Apply stops on the first
+// failed change.
+func (p *ReconcilePlan) Apply(ctx context.Context) []error {
+	if p.IsEmpty() {
+		return nil
+	}
+
+	for _, change := range p.Changes {
+		errs := change.apply(ctx)
+
+		if len(errs) != 0 {
+			return append(
+				[]error{fmt.Errorf("Can't %s %s %q", change.Action, change.Kind, change.Name)},
+				errs...,
+			)
+		}
+	}
+
+	return nil
+}
+
+// String returns human-readable info about change
+func (c *ReconcileChange) String() string {
+	var sign string
+
+	switch c.Action {
+	case ACTION_CREATE:
+		sign = "+"
+	case ACTION_UPDATE:
+		sign = "~"
+	default:
+		sign = "-"
+	}
+
+	return sign + " " + c.Kind + " " + c.Name
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// planMetrics adds changes of metrics metadata to plan
+func (c *Client) planMetrics(ctx context.Context, plan *ReconcilePlan, metrics []*Metric) []error {
+	for _, m := range metrics {
+		m := *m
+		current, errs := c.GetMetricContext(ctx, m.Name)
+
+		action := ACTION_UPDATE
+
+		switch {
+		case isNotFound(errs):
+			action = ACTION_CREATE
+		case len(errs) != 0:
+			return errs
+		case !hasChanges(m, current):
+			continue
+		}
+
+		plan.add(action, KIND_METRIC, m.Name, func(ctx context.Context) []error {
+			return c.UpdateMetricContext(ctx, m)
+		})
+	}
+
+	return nil
+}
+
+// planServices adds changes of notification services to plan and returns list
+// of existing services and references to services by title
+func (c *Client) planServices(ctx context.Context, plan *ReconcilePlan, desired State) ([]*Service, map[string]*serviceRef, []error) {
+	if len(desired.Services) == 0 && !hasServiceTitles(desired.Alerts) {
+		return nil, nil, nil
+	}
+
+	current, errs := c.listAllServices(ctx)
+
+	if len(errs) != 0 {
+		return nil, nil, errs
+	}
+
+	refs := make(map[string]*serviceRef)
+
+	for _, service := range current {
+		refs[service.Title] = &serviceRef{id: service.ID}
+	}
+
+	for _, service := range desired.Services {
+		service := *service
+		service.ID = 0
+		existing := findService(current, service.Title)
+
+		switch {
+		case existing == nil:
+			ref := &serviceRef{}
+			refs[service.Title] = ref
+
+			plan.add(ACTION_CREATE, KIND_SERVICE, service.Title, func(ctx context.Context) []error {
+				created, errs := c.CreateServiceContext(ctx, service)
+
+				if len(errs) == 0 {
+					ref.id = created.ID
+				}
+
+				return errs
+			})
+		case hasChanges(service, existing):
+			service.ID = existing.ID
+			plan.add(ACTION_UPDATE, KIND_SERVICE, service.Title, func(ctx context.Context) []error {
+				return c.UpdateServiceContext(ctx, service)
+			})
+		}
+	}
+
+	return current, refs, nil
+}
+
+// pruneServices adds removal of services which are absent in desired state
+// to plan
+func (c *Client) pruneServices(plan *ReconcilePlan, desired State, current []*Service, opts ReconcileOptions) {
+	if len(desired.Services) == 0 {
+		return
+	}
+
+	for _, service := range current {
+		switch {
+		case !strings.HasPrefix(service.Title, opts.PrunePrefix),
+			findService(desired.Services, service.Title) != nil,
+			isServiceUsed(desired.Alerts, service):
+			continue
+		}
+
+		id := service.ID
+
+		plan.add(ACTION_DELETE, KIND_SERVICE, service.Title, func(ctx context.Context) []error {
+			return c.DeleteServiceContext(ctx, id)
+		})
+	}
+}
+
+// planSpaces adds changes of spaces and charts to plan
+func (c *Client) planSpaces(ctx context.Context, plan *ReconcilePlan, spaces []*Space, opts ReconcileOptions) []error {
+	if len(spaces) == 0 {
+		return nil
+	}
+
+	current, errs := c.listAllSpaces(ctx)
+
+	if len(errs) != 0 {
+		return errs
+	}
+
+	for _, space := range spaces {
+		ref := &spaceRef{}
+		name := space.Name
+		existing := findSpace(current, name)
+
+		var currentCharts []*Chart
+
+		if existing == nil {
+			plan.add(ACTION_CREATE, KIND_SPACE, name, func(ctx context.Context) []error {
+				created, errs := c.CreateSpaceContext(ctx, Space{Name: name})
+
+				if len(errs) == 0 {
+					ref.id = created.ID
+				}
+
+				return errs
+			})
+		} else {
+			ref.id = existing.ID
+			currentCharts, errs = c.ListChartsContext(ctx, existing.ID)
+
+			if len(errs) != 0 {
+				return errs
+			}
+		}
+
+		// Charts are pruned only in spaces which can be pruned themselves
+		pruneCharts := opts.Prune && strings.HasPrefix(name, opts.PrunePrefix)
+
+		c.planCharts(plan, ref, name, space.Charts, currentCharts, pruneCharts)
+	}
+
+	if !opts.Prune {
+		return nil
+	}
+
+	for _, space := range current {
+		if !strings.HasPrefix(space.Name, opts.PrunePrefix) || findSpace(spaces, space.Name) != nil {
+			continue
+		}
+
+		id := space.ID
+
+		plan.add(ACTION_DELETE, KIND_SPACE, space.Name, func(ctx context.Context) []error {
+			return c.DeleteSpaceContext(ctx, id)
+		})
+	}
+
+	return nil
+}
+
+// planCharts adds changes of space charts to plan
+func (c *Client) planCharts(plan *ReconcilePlan, ref *spaceRef, spaceName string, charts, current []*Chart, prune bool) {
+	for _, chart := range charts {
+		chart := *chart
+		chart.ID = 0
+		existing := findChart(current, chart.Name)
+		name := spaceName + "/" + chart.Name
+
+		switch {
+		case existing == nil:
+			plan.add(ACTION_CREATE, KIND_CHART, name, func(ctx context.Context) []error {
+				_, errs := c.CreateChartContext(ctx, ref.id, chart)
+				return errs
+			})
+		case hasChanges(chart, existing):
+			chart.ID = existing.ID
+			plan.add(ACTION_UPDATE, KIND_CHART, name, func(ctx context.Context) []error {
+				return c.UpdateChartContext(ctx, ref.id, chart)
+			})
+		}
+	}
+
+	if !prune {
+		return
+	}
+
+	for _, chart := range current {
+		if findChart(charts, chart.Name) != nil {
+			continue
+		}
+
+		id := chart.ID
+
+		plan.add(ACTION_DELETE, KIND_CHART, spaceName+"/"+chart.Name, func(ctx context.Context) []error {
+			return c.DeleteChartContext(ctx, ref.id, id)
+		})
+	}
+}
+
+// planAlerts adds changes of alerts to plan
+func (c *Client) planAlerts(ctx context.Context, plan *ReconcilePlan, alerts []*Alert, refs map[string]*serviceRef, opts ReconcileOptions) []error {
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	current, errs := c.listAllAlerts(ctx)
+
+	if len(errs) != 0 {
+		return errs
+	}
+
+	for _, alert := range alerts {
+		alert := *alert
+		alert.ID = 0
+		existing := findAlert(current, alert.Name)
+
+		// Check that all services are known before adding any changes
+		resolved, err := resolveAlertServices(alert, refs)
+
+		if err != nil {
+			return []error{err}
+		}
+
+		if existing == nil {
+			plan.add(ACTION_CREATE, KIND_ALERT, alert.Name, func(ctx context.Context) []error {
+				alert, _ := resolveAlertServices(alert, refs)
+				_, errs := c.CreateAlertContext(ctx, alert)
+				return errs
+			})
+
+			continue
+		}
+
+		alert = mergeAlert(alert, existing)
+		resolved = mergeAlert(resolved, existing)
+
+		if hasChanges(resolved.toPayload(), existing.toPayload()) {
+			plan.add(ACTION_UPDATE, KIND_ALERT, alert.Name, func(ctx context.Context) []error {
+				alert, _ := resolveAlertServices(alert, refs)
+				return c.UpdateAlertContext(ctx, alert)
+			})
+		}
+	}
+
+	if !opts.Prune {
+		return nil
+	}
+
+	for _, alert := range current {
+		if !strings.HasPrefix(alert.Name, opts.PrunePrefix) || findAlert(alerts, alert.Name) != nil {
+			continue
+		}
+
+		id := alert.ID
+
+		plan.add(ACTION_DELETE, KIND_ALERT, alert.Name, func(ctx context.Context) []error {
+			return c.DeleteAlertContext(ctx, id)
+		})
+	}
+
+	return nil
+}
+
+// listAllSpaces fetches all pages of spaces list
+func (c *Client) listAllSpaces(ctx context.Context) ([]*Space, []error) {
+	var result []*Space
+
+	opts := ListSpacesOptions{}
+
+	for {
+		list, errs := c.ListSpacesContext(ctx, opts)
+
+		if len(errs) != 0 {
+			return nil, errs
+		}
+
+		result = append(result, list.Spaces...)
+
+		if len(list.Spaces) == 0 || !list.Query.HasMore() {
+			return result, nil
+		}
+
+		opts.Pagination = opts.Pagination.Next(list.Query)
+	}
+}
+
+// listAllServices fetches all pages of notification services list
+func (c *Client) listAllServices(ctx context.Context) ([]*Service, []error) {
+	var result []*Service
+
+	opts := ListServicesOptions{}
+
+	for {
+		list, errs := c.ListServicesContext(ctx, opts)
+
+		if len(errs) != 0 {
+			return nil, errs
+		}
+
+		result = append(result, list.Services...)
+
+		if len(list.Services) == 0 || !list.Query.HasMore() {
+			return result, nil
+		}
+
+		opts.Pagination = opts.Pagination.Next(list.Query)
+	}
+}
+
+// listAllAlerts fetches all pages of alerts list
+func (c *Client) listAllAlerts(ctx context.Context) ([]*Alert, []error) {
+	var result []*Alert
+
+	opts := ListAlertsOptions{}
+
+	for {
+		list, errs := c.ListAlertsContext(ctx, opts)
+
+		if len(errs) != 0 {
+			return nil, errs
+		}
+
+		result = append(result, list.Alerts...)
+
+		if len(list.Alerts) == 0 || !list.Query.HasMore() {
+			return result, nil
+		}
+
+		opts.Pagination = opts.Pagination.Next(list.Query)
+	}
+}
+
+// add adds change to plan
+func (p *ReconcilePlan) add(action, kind, name string, apply func(ctx context.Context) []error) {
+	p.Changes = append(p.Changes, &ReconcileChange{
+		Action: action,
+		Kind:   kind,
+		Name:   name,
+		apply:  apply,
+	})
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// findSpace returns space with given name
+func findSpace(spaces []*Space, name string) *Space {
+	for _, s := range spaces {
+		if s.Name == name {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// findChart returns chart with given name
+func findChart(charts []*Chart, name string) *Chart {
+	for _, ch := range charts {
+		if ch.Name == name {
+			return ch
+		}
+	}
+
+	return nil
+}
+
+// findAlert returns alert with given name
+func findAlert(alerts []*Alert, name string) *Alert {
+	for _, a := range alerts {
+		if a.Name == name {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// findService returns service with given title
+func findService(services []*Service, title string) *Service {
+	for _, s := range services {
+		if s.Title == title {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// hasServiceTitles returns true if any of alerts references service by title
+func hasServiceTitles(alerts []*Alert) bool {
+	for _, a := range alerts {
+		for _, s := range a.Services {
+			if s != nil && s.ID == 0 && s.Title != "" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isServiceUsed returns true if any of alerts references given service by ID
+// or title
+func isServiceUsed(alerts []*Alert, service *Service) bool {
+	for _, a := range alerts {
+		for _, s := range a.Services {
+			if s.ID == service.ID || (s.ID == 0 && s.Title == service.Title) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// resolveAlertServices returns copy of alert where services referenced by title
+// are replaced by their IDs. Services which will be created by plan have zero
+// IDs until plan is applied.
+func resolveAlertServices(a Alert, refs map[string]*serviceRef) (Alert, error) {
+	if !hasServiceTitles([]*Alert{&a}) {
+		return a, nil
+	}
+
+	services := make([]*AlertService, len(a.Services))
+
+	for i, s := range a.Services {
+		if s.ID != 0 || s.Title == "" {
+			services[i] = s
+			continue
+		}
+
+		ref := refs[s.Title]
+
+		if ref == nil {
+			return a, fmt.Errorf("Alert %q uses unknown service %q", a.Name, s.Title)
+		}
+
+		services[i] = &AlertService{ID: ref.id, Title: s.Title}
+	}
+
+	a.Services = services
+
+	return a, nil
+}
+
+// mergeAlert fills properties which are not set in desired alert with values
+// from existing alert
+func mergeAlert(desired Alert, existing *Alert) Alert {
+	desired.ID = existing.ID
+
+	if desired.Services == nil {
+		desired.Services = existing.Services
+	}
+
+	if desired.Attributes == nil {
+		desired.Attributes = existing.Attributes
+	}
+
+	if desired.Active == nil {
+		desired.Active = existing.Active
+	}
+
+	return desired
+}
+
+// isNotFound returns true if errors contain API error with 404 status
+func isNotFound(errs []error) bool {
+	return len(errs) != 0 && errors.Is(errs[0], ErrNotFound)
+}
+
+// hasChanges returns true if current object differs from desired. Only
+// properties set in desired object are compared.
+func hasChanges(desired, current interface{}) bool {
+	var d, c interface{}
+
+	if !decodeAsJSON(desired, &d) || !decodeAsJSON(current, &c) {
+		return true
+	}
+
+	return !isSubset(d, c)
+}
+
+// decodeAsJSON converts struct to generic JSON value
+func decodeAsJSON(v interface{}, result *interface{}) bool {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(data, result) == nil
+}
+
+// isSubset returns true if all values set in desired are equal to values in current
+func isSubset(desired, current interface{}) bool {
+	switch d := desired.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		c, ok := current.(map[string]interface{})
+
+		if !ok {
+			return false
+		}
+
+		for k, v := range d {
+			if !isSubset(v, c[k]) {
+				return false
+			}
+		}
+
+		return true
+	case []interface{}:
+		c, ok := current.([]interface{})
+
+		if !ok || len(c) != len(d) {
+			return false
+		}
+
+		for i := range d {
+			if !isSubset(d[i], c[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return reflect.DeepEqual(desired, current)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// validateState validate desired state
+func validateState(s State) error {
+	names := make(map[string]bool)
+
+	for _, m := range s.Metrics {
+		if m == nil {
+			return errors.New("State can't contain nil metrics")
+		}
+
+		err := m.Validate()
+
+		if err != nil {
+			return err
+		}
+
+		if names["m:"+m.Name] {
+			return fmt.Errorf("State contains duplicate metric %q", m.Name)
+		}
+
+		names["m:"+m.Name] = true
+	}
+
+	for _, service := range s.Services {
+		if service == nil {
+			return errors.New("State can't contain nil services")
+		}
+
+		err := service.Validate()
+
+		if err != nil {
+			return err
+		}
+
+		if names["n:"+service.Title] {
+			return fmt.Errorf("State contains duplicate service %q", service.Title)
+		}
+
+		names["n:"+service.Title] = true
+	}
+
+	for _, space := range s.Spaces {
+		if space == nil {
+			return errors.New("State can't contain nil spaces")
+		}
+
+		err := space.Validate()
+
+		if err != nil {
+			return err
+		}
+
+		if names["s:"+space.Name] {
+			return fmt.Errorf("State contains duplicate space %q", space.Name)
+		}
+
+		names["s:"+space.Name] = true
+
+		for _, chart := range space.Charts {
+			if chart == nil {
+				return errors.New("State can't contain nil charts")
+			}
+
+			err = chart.Validate()
+
+			if err != nil {
+				return err
+			}
+
+			key := "c:" + space.Name + "\x00" + chart.Name
+
+			if names[key] {
+				return fmt.Errorf("State contains duplicate chart %q in space %q", chart.Name, space.Name)
+			}
+
+			names[key] = true
+		}
+	}
+
+	for _, alert := range s.Alerts {
+		if alert == nil {
+			return errors.New("State can't contain nil alerts")
+		}
+
+		// Services referenced by title are resolved while planning
+		a := *alert
+		a.Services = nil
+
+		for _, service := range alert.Services {
+			if service == nil || service.ID != 0 || service.Title == "" {
+				a.Services = append(a.Services, service)
+			}
+		}
+
+		err := a.Validate()
+
+		if err != nil {
+			return err
+		}
+
+		if names["a:"+alert.Name] {
+			return fmt.Errorf("State contains duplicate alert %q", alert.Name)
+		}
+
+		names["a:"+alert.Name] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,393 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/essentialkaos/ek/v12/req"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Registry is data source with named counter, gauge and timer handles which
+// values are sent to Librato every period. Values which weren't sent (after
+// all retries) are dropped, errors are passed to ErrorHandler.
+type Registry struct {
+	lastSendingDate int64 // must be first for 64-bit alignment of atomic operations
+	client          *Client
+	period          time.Duration
+	handles         map[string]handle
+	order           []string
+	handlesMu       sync.Mutex
+
+	// Function executed if we have errors while sending data to Librato
+	ErrorHandler func(errs []error)
+	Engine       *req.Engine
+
+	// RetryPolicy is policy used for retrying failed sendings (if not set,
+	// client retry policy will be used)
+	RetryPolicy *RetryPolicy
+}
+
+// CounterHandle is handle of monotonically increasing counter
+type CounterHandle struct {
+	value  int64 // must be first for 64-bit alignment of atomic operations
+	name   string
+	source string
+}
+
+// GaugeHandle is handle of gauge with current value
+type GaugeHandle struct {
+	value  uint64 // must be first for 64-bit alignment of atomic operations
+	isSet  uint32
+	name   string
+	source string
+}
+
+// TimerHandle is handle of timer which reports durations (in milliseconds) as
+// multi-sample gauge
+type TimerHandle struct {
+	name   string
+	source string
+	data   *aggregate
+	dataMu sync.Mutex
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// handle is interface for registry handles
+type handle interface {
+	snapshot() []Measurement
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewRegistry creates new registry of counter, gauge and timer handles
+func NewRegistry(period time.Duration) *Registry {
+	return defaultClient.NewRegistry(period)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewRegistry creates new registry of counter, gauge and timer handles
+func (c *Client) NewRegistry(period time.Duration) *Registry {
+	registry := &Registry{
+		client:          c,
+		period:          period,
+		handles:         make(map[string]handle),
+		lastSendingDate: -1,
+	}
+
+	if UseGlobalEngine {
+		registry.Engine = c.getEngine()
+	} else {
+		registry.Engine = &req.Engine{}
+	}
+
+	registerSource(registry)
+
+	return registry
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Counter returns counter handle with given name and source (source can be empty)
+func (r *Registry) Counter(name, source string) (*CounterHandle, error) {
+	err := validateHandle(name, source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	h := r.getHandle("c:"+name+"\x00"+source, func() handle {
+		return &CounterHandle{name: name, source: source}
+	})
+
+	return h.(*CounterHandle), nil
+}
+
+// Gauge returns gauge handle with given name and source (source can be empty)
+func (r *Registry) Gauge(name, source string) (*GaugeHandle, error) {
+	err := validateHandle(name, source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	h := r.getHandle("g:"+name+"\x00"+source, func() handle {
+		return &GaugeHandle{name: name, source: source}
+	})
+
+	return h.(*GaugeHandle), nil
+}
+
+// Timer returns timer handle with given name and source (source can be empty)
+func (r *Registry) Timer(name, source string) (*TimerHandle, error) {
+	err := validateHandle(name, source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	h := r.getHandle("t:"+name+"\x00"+source, func() handle {
+		return &TimerHandle{name: name, source: source}
+	})
+
+	return h.(*TimerHandle), nil
+}
+
+// Histogram returns histogram handle with given name, source (can be empty)
+// and list of reported percentiles
+func (r *Registry) Histogram(name, source string, percentiles ...float64) (*Histogram, error) {
+	err := validateHandle(name, source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	h := r.getHandle("h:"+name+"\x00"+source, func() handle {
+		return NewHistogram(name, source, percentiles...)
+	})
+
+	return h.(*Histogram), nil
+}
+
+// Send sends values of all handles to Librato service
+func (r *Registry) Send() []error {
+	return r.SendContext(context.Background())
+}
+
+// SendContext sends values of all handles to Librato service with given context
+func (r *Registry) SendContext(ctx context.Context) []error {
+	if !r.client.hasCredentials() {
+		return errAccessCredentials
+	}
+
+	data := r.snapshot()
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	atomic.StoreInt64(&r.lastSendingDate, time.Now().Unix())
+
+	errs, _, _ := r.client.sendMeasurements(ctx, data, sendOptions{
+		engine:   r.Engine,
+		policy:   r.getRetryPolicy(),
+		throttle: true,
+	})
+
+	r.execErrorHandler(errs)
+
+	return errs
+}
+
+// Flush synchronously sends values of all handles to Librato service
+func (r *Registry) Flush(ctx context.Context) []error {
+	return r.SendContext(ctx)
+}
+
+// Close removes registry from the list of data sources processed by sending loop
+func (r *Registry) Close() {
+	unregisterSource(r)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Inc increments counter by 1
+func (h *CounterHandle) Inc() {
+	atomic.AddInt64(&h.value, 1)
+}
+
+// Add increments counter by given value
+func (h *CounterHandle) Add(n int64) {
+	atomic.AddInt64(&h.value, n)
+}
+
+// Value returns current counter value
+func (h *CounterHandle) Value() int64 {
+	return atomic.LoadInt64(&h.value)
+}
+
+// Set sets current gauge value
+func (h *GaugeHandle) Set(v float64) {
+	atomic.StoreUint64(&h.value, math.Float64bits(v))
+	atomic.StoreUint32(&h.isSet, 1)
+}
+
+// Update changes current gauge value by given delta
+func (h *GaugeHandle) Update(delta float64) {
+	for {
+		old := atomic.LoadUint64(&h.value)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+
+		if atomic.CompareAndSwapUint64(&h.value, old, updated) {
+			break
+		}
+	}
+
+	atomic.StoreUint32(&h.isSet, 1)
+}
+
+// Value returns current gauge value
+func (h *GaugeHandle) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&h.value))
+}
+
+// Record adds duration sample to timer
+func (h *TimerHandle) Record(d time.Duration) {
+	value := float64(d) / float64(time.Millisecond)
+
+	h.dataMu.Lock()
+
+	if h.data == nil {
+		h.data = &aggregate{name: h.name, source: h.source}
+	}
+
+	h.data.addSample(value)
+
+	h.dataMu.Unlock()
+}
+
+// Since adds duration elapsed since given moment to timer
+func (h *TimerHandle) Since(start time.Time) {
+	h.Record(time.Since(start))
+}
+
+// Time executes given function and adds its execution duration to timer
+func (h *TimerHandle) Time(fn func()) {
+	start := time.Now()
+	fn()
+	h.Since(start)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getHandle returns handle with given key or creates new one
+func (r *Registry) getHandle(key string, create func() handle) handle {
+	r.handlesMu.Lock()
+	defer r.handlesMu.Unlock()
+
+	h, ok := r.handles[key]
+
+	if !ok {
+		h = create()
+		r.handles[key] = h
+		r.order = append(r.order, key)
+	}
+
+	return h
+}
+
+// snapshot returns measurements with current values of all handles
+func (r *Registry) snapshot() []Measurement {
+	r.handlesMu.Lock()
+
+	handles := make([]handle, 0, len(r.order))
+
+	for _, key := range r.order {
+		handles = append(handles, r.handles[key])
+	}
+
+	r.handlesMu.Unlock()
+
+	var result []Measurement
+
+	for _, h := range handles {
+		result = append(result, h.snapshot()...)
+	}
+
+	return result
+}
+
+// getPeriod return sending period
+func (r *Registry) getPeriod() time.Duration {
+	return r.period
+}
+
+// getLastSendingDate return last sending date
+func (r *Registry) getLastSendingDate() int64 {
+	return atomic.LoadInt64(&r.lastSendingDate)
+}
+
+// getRetryPolicy returns retry policy used for sending
+func (r *Registry) getRetryPolicy() *RetryPolicy {
+	if r.RetryPolicy != nil {
+		return r.RetryPolicy
+	}
+
+	return r.client.getRetryPolicy()
+}
+
+// execErrorHandler exec error handler if present
+func (r *Registry) execErrorHandler(errs []error) {
+	if r.ErrorHandler == nil || len(errs) == 0 {
+		return
+	}
+
+	r.ErrorHandler(errs)
+}
+
+// snapshot returns counter with current value
+func (h *CounterHandle) snapshot() []Measurement {
+	return []Measurement{Counter{Name: h.name, Source: h.source, Value: h.Value()}}
+}
+
+// snapshot returns gauge with current value (non-finite values are skipped)
+func (h *GaugeHandle) snapshot() []Measurement {
+	if atomic.LoadUint32(&h.isSet) == 0 {
+		return nil
+	}
+
+	value := h.Value()
+
+	if !isFinite(value) {
+		return nil
+	}
+
+	return []Measurement{Gauge{Name: h.name, Source: h.source, Value: value}}
+}
+
+// snapshot returns multi-sample gauge with durations recorded since
+// previous snapshot
+func (h *TimerHandle) snapshot() []Measurement {
+	h.dataMu.Lock()
+
+	data := h.data
+	h.data = nil
+
+	h.dataMu.Unlock()
+
+	if data == nil {
+		return nil
+	}
+
+	return []Measurement{data.toMeasurement(0)}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// validateHandle validates name and source of registry handle
+func validateHandle(name, source string) error {
+	switch {
+	case name == "":
+		return errors.New("Handle property Name can't be empty")
+	case len(name) > 255:
+		return errors.New("Length of handle property Name must be 255 or fewer characters")
+	case len(source) > 255:
+		return errors.New("Length of handle property Source must be 255 or fewer characters")
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// RetryPolicy contains configuration for retrying failed requests. Policy is
+// applied only to idempotent requests (GET, PUT, DELETE) and measurements
+// submission, other POST requests are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is maximum number of attempts (including the first one)
+	MaxAttempts int
+
+	// BaseBackoff is delay before the first retry, every next delay is twice
+	// as long as previous
+	BaseBackoff time.Duration
+
+	// MaxBackoff is maximum delay between attempts
+	MaxBackoff time.Duration
+
+	// Jitter is fraction (0-1) of delay which will be randomly added to or
+	// subtracted from it
+	Jitter float64
+
+	// RetryableStatusCodes is list of HTTP status codes which are considered
+	// as temporary failures. Network errors are always retryable.
+	RetryableStatusCodes []int
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// DefaultRetryPolicy is retry policy used by package-level methods
+var DefaultRetryPolicy *RetryPolicy
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewRetryPolicy creates new retry policy with default preferences
+func NewRetryPolicy(maxAttempts int) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: maxAttempts,
+		BaseBackoff: 500 * time.Millisecond,
+		MaxBackoff:  30 * time.Second,
+		Jitter:      0.2,
+		RetryableStatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// isRetryable returns true if request with given status code could be retried
+func (p *RetryPolicy) isRetryable(ctx context.Context, attempt, statusCode int) bool {
+	if p == nil || attempt >= p.MaxAttempts || ctx.Err() != nil {
+		return false
+	}
+
+	if statusCode == 0 {
+		return true
+	}
+
+	for _, code := range p.RetryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getDelay returns delay before next attempt
+func (p *RetryPolicy) getDelay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if p.MaxBackoff > 0 && retryAfter > p.MaxBackoff {
+			return p.MaxBackoff
+		}
+
+		return retryAfter
+	}
+
+	delay := p.BaseBackoff
+
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || delay < p.MaxBackoff); i++ {
+		if delay > math.MaxInt64/2 {
+			delay = math.MaxInt64
+			break
+		}
+
+		delay *= 2
+	}
+
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 && delay > 0 {
+		jitter := float64(delay) * p.Jitter
+		delay += time.Duration(jitter * (rand.Float64()*2 - 1))
+	}
+
+	if delay < 0 {
+		return 0
+	}
+
+	return delay
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// isIdempotentMethod returns true if request with given method could be
+// safely repeated
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+// parseRetryAfter parses value of Retry-After header
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+
+	if value == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(value)
+
+	if err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(value)
+
+	if err != nil {
+		return 0
+	}
+
+	return time.Until(date)
+}
@@ -0,0 +1,489 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/essentialkaos/ek/v12/req"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Service types
+const (
+	SERVICE_TYPE_MAIL      = "mail"
+	SERVICE_TYPE_SLACK     = "slack"
+	SERVICE_TYPE_PAGERDUTY = "pagerduty"
+	SERVICE_TYPE_WEBHOOK   = "webhook"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Service contains notification service used by alerts
+type Service struct {
+	// Unique ID of the service (set by API)
+	ID int64
+
+	// Display title of the service
+	Title string
+
+	// Service type specific settings
+	Settings ServiceSettings
+}
+
+// ServiceSettings is interface for service type specific settings
+type ServiceSettings interface {
+	// Type returns service type
+	Type() string
+
+	// Validate validates settings
+	Validate() error
+}
+
+// MailSettings contains settings of email service
+type MailSettings struct {
+	Addresses []string
+}
+
+// SlackSettings contains settings of Slack service
+type SlackSettings struct {
+	// Incoming webhook URL
+	URL string `json:"url"`
+}
+
+// PagerDutySettings contains settings of PagerDuty service
+type PagerDutySettings struct {
+	// Integration key of PagerDuty service
+	ServiceKey string `json:"service_key"`
+
+	// Type of created events (trigger by default)
+	EventType string `json:"event_type,omitempty"`
+
+	// Description of created incidents
+	Description string `json:"description,omitempty"`
+}
+
+// WebhookSettings contains settings of webhook service
+type WebhookSettings struct {
+	// URL which receives POST requests with alert payload
+	URL string `json:"url"`
+}
+
+// CustomServiceSettings contains settings of service with type which isn't
+// supported by typed settings
+type CustomServiceSettings struct {
+	ServiceType string
+	Values      map[string]interface{}
+}
+
+// ListServicesOptions contains options of services listing
+type ListServicesOptions struct {
+	Pagination
+}
+
+// ServicesList contains page of services list
+type ServicesList struct {
+	Query    QueryInfo  `json:"query"`
+	Services []*Service `json:"services"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type serviceJSON struct {
+	ID       int64           `json:"id,omitempty"`
+	Type     string          `json:"type"`
+	Title    string          `json:"title"`
+	Settings json.RawMessage `json:"settings"`
+}
+
+type mailSettingsJSON struct {
+	Addresses string `json:"addresses"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ListServices synchronously fetches list of notification services from librato
+func ListServices(opts ListServicesOptions) (*ServicesList, []error) {
+	return defaultClient.ListServices(opts)
+}
+
+// ListServicesContext synchronously fetches list of notification services from
+// librato with given context
+func ListServicesContext(ctx context.Context, opts ListServicesOptions) (*ServicesList, []error) {
+	return defaultClient.ListServicesContext(ctx, opts)
+}
+
+// GetService synchronously fetches notification service from librato
+func GetService(id int64) (*Service, []error) {
+	return defaultClient.GetService(id)
+}
+
+// GetServiceContext synchronously fetches notification service from librato
+// with given context
+func GetServiceContext(ctx context.Context, id int64) (*Service, []error) {
+	return defaultClient.GetServiceContext(ctx, id)
+}
+
+// CreateService synchronously creates notification service on librato
+func CreateService(s Service) (*Service, []error) {
+	return defaultClient.CreateService(s)
+}
+
+// CreateServiceContext synchronously creates notification service on librato
+// with given context
+func CreateServiceContext(ctx context.Context, s Service) (*Service, []error) {
+	return defaultClient.CreateServiceContext(ctx, s)
+}
+
+// UpdateService synchronously updates notification service on librato
+func UpdateService(s Service) []error {
+	return defaultClient.UpdateService(s)
+}
+
+// UpdateServiceContext synchronously updates notification service on librato
+// with given context
+func UpdateServiceContext(ctx context.Context, s Service) []error {
+	return defaultClient.UpdateServiceContext(ctx, s)
+}
+
+// DeleteService synchronously removes notification service on librato
+func DeleteService(id int64) []error {
+	return defaultClient.DeleteService(id)
+}
+
+// DeleteServiceContext synchronously removes notification service on librato
+// with given context
+func DeleteServiceContext(ctx context.Context, id int64) []error {
+	return defaultClient.DeleteServiceContext(ctx, id)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ListServices synchronously fetches list of notification services from librato
+func (c *Client) ListServices(opts ListServicesOptions) (*ServicesList, []error) {
+	return c.ListServicesContext(context.Background(), opts)
+}
+
+// ListServicesContext synchronously fetches list of notification services from
+// librato with given context
+func (c *Client) ListServicesContext(ctx context.Context, opts ListServicesOptions) (*ServicesList, []error) {
+	query := url.Values{}
+
+	opts.Pagination.apply(query)
+
+	result := &ServicesList{}
+	errs := c.execJSONRequest(ctx, req.GET, "/v1/services", query, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// GetService synchronously fetches notification service from librato
+func (c *Client) GetService(id int64) (*Service, []error) {
+	return c.GetServiceContext(context.Background(), id)
+}
+
+// GetServiceContext synchronously fetches notification service from librato
+// with given context
+func (c *Client) GetServiceContext(ctx context.Context, id int64) (*Service, []error) {
+	if id <= 0 {
+		return nil, errInvalidServiceID
+	}
+
+	result := &Service{}
+	errs := c.execJSONRequest(ctx, req.GET, getServicePath(id), nil, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// CreateService synchronously creates notification service on librato
+func (c *Client) CreateService(s Service) (*Service, []error) {
+	return c.CreateServiceContext(context.Background(), s)
+}
+
+// CreateServiceContext synchronously creates notification service on librato
+// with given context
+func (c *Client) CreateServiceContext(ctx context.Context, s Service) (*Service, []error) {
+	err := s.Validate()
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	s.ID = 0
+
+	result := &Service{}
+	errs := c.execJSONRequest(ctx, req.POST, "/v1/services", nil, s, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// UpdateService synchronously updates notification service on librato
+func (c *Client) UpdateService(s Service) []error {
+	return c.UpdateServiceContext(context.Background(), s)
+}
+
+// UpdateServiceContext synchronously updates notification service on librato
+// with given context
+func (c *Client) UpdateServiceContext(ctx context.Context, s Service) []error {
+	if s.ID <= 0 {
+		return errInvalidServiceID
+	}
+
+	err := s.Validate()
+
+	if err != nil {
+		return []error{err}
+	}
+
+	return c.execJSONRequest(ctx, req.PUT, getServicePath(s.ID), nil, s, nil)
+}
+
+// DeleteService synchronously removes notification service on librato
+func (c *Client) DeleteService(id int64) []error {
+	return c.DeleteServiceContext(context.Background(), id)
+}
+
+// DeleteServiceContext synchronously removes notification service on librato
+// with given context
+func (c *Client) DeleteServiceContext(ctx context.Context, id int64) []error {
+	if id <= 0 {
+		return errInvalidServiceID
+	}
+
+	return c.execJSONRequest(ctx, req.DELETE, getServicePath(id), nil, nil, nil)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Validate validates service struct
+func (s Service) Validate() error {
+	return validateService(s)
+}
+
+// MarshalJSON encodes service with type defined by settings
+func (s Service) MarshalJSON() ([]byte, error) {
+	if s.Settings == nil {
+		return nil, errors.New("Service property Settings can't be nil")
+	}
+
+	settings, err := json.Marshal(s.Settings)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(serviceJSON{
+		ID:       s.ID,
+		Type:     s.Settings.Type(),
+		Title:    s.Title,
+		Settings: settings,
+	})
+}
+
+// UnmarshalJSON decodes service with typed settings
+func (s *Service) UnmarshalJSON(data []byte) error {
+	v := serviceJSON{}
+	err := json.Unmarshal(data, &v)
+
+	if err != nil {
+		return err
+	}
+
+	var settings ServiceSettings
+
+	switch v.Type {
+	case SERVICE_TYPE_MAIL:
+		settings = &MailSettings{}
+	case SERVICE_TYPE_SLACK:
+		settings = &SlackSettings{}
+	case SERVICE_TYPE_PAGERDUTY:
+		settings = &PagerDutySettings{}
+	case SERVICE_TYPE_WEBHOOK:
+		settings = &WebhookSettings{}
+	default:
+		settings = &CustomServiceSettings{ServiceType: v.Type}
+	}
+
+	if len(v.Settings) != 0 && string(v.Settings) != "null" {
+		err = json.Unmarshal(v.Settings, settings)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	s.ID, s.Title, s.Settings = v.ID, v.Title, settings
+
+	return nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Type returns service type
+func (s *MailSettings) Type() string {
+	return SERVICE_TYPE_MAIL
+}
+
+// Validate validates email service settings
+func (s *MailSettings) Validate() error {
+	if len(s.Addresses) == 0 {
+		return errors.New("Mail service property Addresses can't be empty")
+	}
+
+	for _, address := range s.Addresses {
+		if !strings.Contains(address, "@") || strings.ContainsAny(address, ", ") {
+			return errors.New("Mail service property Addresses contains invalid address " + address)
+		}
+	}
+
+	return nil
+}
+
+// MarshalJSON encodes email service settings
+func (s *MailSettings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mailSettingsJSON{strings.Join(s.Addresses, ",")})
+}
+
+// UnmarshalJSON decodes email service settings
+func (s *MailSettings) UnmarshalJSON(data []byte) error {
+	v := mailSettingsJSON{}
+	err := json.Unmarshal(data, &v)
+
+	if err != nil {
+		return err
+	}
+
+	s.Addresses = nil
+
+	for _, address := range strings.Split(v.Addresses, ",") {
+		address = strings.TrimSpace(address)
+
+		if address != "" {
+			s.Addresses = append(s.Addresses, address)
+		}
+	}
+
+	return nil
+}
+
+// Type returns service type
+func (s *SlackSettings) Type() string {
+	return SERVICE_TYPE_SLACK
+}
+
+// Validate validates Slack service settings
+func (s *SlackSettings) Validate() error {
+	return validateServiceURL("Slack", s.URL)
+}
+
+// Type returns service type
+func (s *PagerDutySettings) Type() string {
+	return SERVICE_TYPE_PAGERDUTY
+}
+
+// Validate validates PagerDuty service settings
+func (s *PagerDutySettings) Validate() error {
+	if s.ServiceKey == "" {
+		return errors.New("PagerDuty service property ServiceKey can't be empty")
+	}
+
+	return nil
+}
+
+// Type returns service type
+func (s *WebhookSettings) Type() string {
+	return SERVICE_TYPE_WEBHOOK
+}
+
+// Validate validates webhook service settings
+func (s *WebhookSettings) Validate() error {
+	return validateServiceURL("Webhook", s.URL)
+}
+
+// Type returns service type
+func (s *CustomServiceSettings) Type() string {
+	return s.ServiceType
+}
+
+// Validate validates custom service settings
+func (s *CustomServiceSettings) Validate() error {
+	if s.ServiceType == "" {
+		return errors.New("Custom service property ServiceType can't be empty")
+	}
+
+	return nil
+}
+
+// MarshalJSON encodes custom service settings
+func (s *CustomServiceSettings) MarshalJSON() ([]byte, error) {
+	if s.Values == nil {
+		return []byte("{}"), nil
+	}
+
+	return json.Marshal(s.Values)
+}
+
+// UnmarshalJSON decodes custom service settings
+func (s *CustomServiceSettings) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &s.Values)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getServicePath returns API path of service
+func getServicePath(id int64) string {
+	return "/v1/services/" + strconv.FormatInt(id, 10)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// validateService validate service struct
+func validateService(s Service) error {
+	if s.Title == "" {
+		return errors.New("Service property Title can't be empty")
+	}
+
+	if s.Settings == nil {
+		return errors.New("Service property Settings can't be nil")
+	}
+
+	return s.Settings.Validate()
+}
+
+// validateServiceURL validate URL of service
+func validateServiceURL(service, value string) error {
+	if value == "" {
+		return errors.New(service + " service property URL can't be empty")
+	}
+
+	u, err := url.Parse(value)
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New(service + " service property URL must be an absolute HTTP(S) URL")
+	}
+
+	return nil
+}
@@ -0,0 +1,348 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/essentialkaos/ek/v12/req"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// SnapshotOptions contains options of chart or space snapshot
+type SnapshotOptions struct {
+	// ID of the chart (can't be used with SpaceID)
+	ChartID int64
+
+	// ID of the space (can't be used with ChartID)
+	SpaceID int64
+
+	// Type of the chart used for rendering (line, stacked or bignumber, by
+	// default chart type is used, only for chart snapshots)
+	ChartType string
+
+	// Source used for filtering data (only for source-based metrics and chart
+	// snapshots)
+	Source string
+
+	// Duration of the time range in seconds
+	Duration int64
+
+	// Unix timestamp of the end of the time range (current time by default)
+	EndTime int64
+}
+
+// WaitSnapshotOptions contains options of waiting for snapshot rendering
+type WaitSnapshotOptions struct {
+	// Interval between snapshot status checks (2 seconds by default)
+	PollInterval time.Duration
+
+	// Maximum duration of waiting for snapshot image rendering (2 minutes
+	// by default)
+	Timeout time.Duration
+}
+
+// Snapshot contains chart snapshot info
+type Snapshot struct {
+	// Unique ID of the snapshot (extracted from Href)
+	ID int64 `json:"-"`
+
+	// URL of the snapshot in API
+	Href string `json:"href"`
+
+	// URL of the snapshot rendering job in API
+	JobHref string `json:"job_href"`
+
+	// URL of PNG image (empty until image is rendered)
+	ImageHref string `json:"image_href"`
+
+	// Duration of the time range in seconds
+	Duration int64 `json:"duration"`
+
+	// End of the time range
+	EndTime time.Time `json:"end_time"`
+
+	// Dates of creation and last update of the snapshot
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type snapshotPayload struct {
+	Subject  snapshotSubject `json:"subject"`
+	Duration int64           `json:"duration,omitempty"`
+	EndTime  int64           `json:"end_time,omitempty"`
+}
+
+type snapshotSubject struct {
+	Chart   *snapshotChart `json:"chart,omitempty"`
+	SpaceID int64          `json:"space_id,omitempty"`
+}
+
+type snapshotChart struct {
+	ID     int64  `json:"id"`
+	Type   string `json:"type,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+const (
+	// DEFAULT_SNAPSHOT_POLL_INTERVAL is default interval between snapshot
+	// status checks
+	DEFAULT_SNAPSHOT_POLL_INTERVAL = 2 * time.Second
+
+	// DEFAULT_SNAPSHOT_TIMEOUT is default maximum duration of waiting for
+	// snapshot image rendering
+	DEFAULT_SNAPSHOT_TIMEOUT = 2 * time.Minute
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// CreateSnapshot synchronously creates snapshot of chart or space on librato
+func CreateSnapshot(opts SnapshotOptions) (*Snapshot, []error) {
+	return defaultClient.CreateSnapshot(opts)
+}
+
+// CreateSnapshotContext synchronously creates snapshot of chart or space on librato with
+// given context
+func CreateSnapshotContext(ctx context.Context, opts SnapshotOptions) (*Snapshot, []error) {
+	return defaultClient.CreateSnapshotContext(ctx, opts)
+}
+
+// GetSnapshot synchronously fetches snapshot info from librato
+func GetSnapshot(id int64) (*Snapshot, []error) {
+	return defaultClient.GetSnapshot(id)
+}
+
+// GetSnapshotContext synchronously fetches snapshot info from librato with
+// given context
+func GetSnapshotContext(ctx context.Context, id int64) (*Snapshot, []error) {
+	return defaultClient.GetSnapshotContext(ctx, id)
+}
+
+// WaitSnapshot synchronously polls snapshot info until image is rendered
+func WaitSnapshot(id int64, opts WaitSnapshotOptions) (*Snapshot, []error) {
+	return defaultClient.WaitSnapshot(id, opts)
+}
+
+// WaitSnapshotContext synchronously polls snapshot info until image is rendered
+// with given context
+func WaitSnapshotContext(ctx context.Context, id int64, opts WaitSnapshotOptions) (*Snapshot, []error) {
+	return defaultClient.WaitSnapshotContext(ctx, id, opts)
+}
+
+// DownloadSnapshot synchronously downloads PNG image of rendered snapshot
+func DownloadSnapshot(s *Snapshot) ([]byte, []error) {
+	return defaultClient.DownloadSnapshot(s)
+}
+
+// DownloadSnapshotContext synchronously downloads PNG image of rendered snapshot
+// with given context
+func DownloadSnapshotContext(ctx context.Context, s *Snapshot) ([]byte, []error) {
+	return defaultClient.DownloadSnapshotContext(ctx, s)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// CreateSnapshot synchronously creates snapshot of chart or space on librato
+func (c *Client) CreateSnapshot(opts SnapshotOptions) (*Snapshot, []error) {
+	return c.CreateSnapshotContext(context.Background(), opts)
+}
+
+// CreateSnapshotContext synchronously creates snapshot of chart or space on librato with
+// given context
+func (c *Client) CreateSnapshotContext(ctx context.Context, opts SnapshotOptions) (*Snapshot, []error) {
+	err := validateSnapshotOptions(opts)
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	payload := snapshotPayload{
+		Duration: opts.Duration,
+		EndTime:  opts.EndTime,
+	}
+
+	if opts.SpaceID > 0 {
+		payload.Subject.SpaceID = opts.SpaceID
+	} else {
+		payload.Subject.Chart = &snapshotChart{
+			ID: opts.ChartID, Type: opts.ChartType, Source: opts.Source,
+		}
+	}
+
+	result := &Snapshot{}
+	errs := c.execJSONRequest(ctx, req.POST, "/v1/snapshots", nil, payload, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	result.ID = parseSnapshotID(result.Href)
+
+	return result, nil
+}
+
+// GetSnapshot synchronously fetches snapshot info from librato
+func (c *Client) GetSnapshot(id int64) (*Snapshot, []error) {
+	return c.GetSnapshotContext(context.Background(), id)
+}
+
+// GetSnapshotContext synchronously fetches snapshot info from librato with
+// given context
+func (c *Client) GetSnapshotContext(ctx context.Context, id int64) (*Snapshot, []error) {
+	if id <= 0 {
+		return nil, errInvalidSnapshotID
+	}
+
+	result := &Snapshot{}
+	errs := c.execJSONRequest(ctx, req.GET, "/v1/snapshots/"+strconv.FormatInt(id, 10), nil, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	result.ID = id
+
+	return result, nil
+}
+
+// WaitSnapshot synchronously polls snapshot info until image is rendered
+func (c *Client) WaitSnapshot(id int64, opts WaitSnapshotOptions) (*Snapshot, []error) {
+	return c.WaitSnapshotContext(context.Background(), id, opts)
+}
+
+// WaitSnapshotContext synchronously polls snapshot info until image is rendered
+// with given context
+func (c *Client) WaitSnapshotContext(ctx context.Context, id int64, opts WaitSnapshotOptions) (*Snapshot, []error) {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DEFAULT_SNAPSHOT_POLL_INTERVAL
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = DEFAULT_SNAPSHOT_TIMEOUT
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	for {
+		snapshot, errs := c.GetSnapshotContext(ctx, id)
+
+		if len(errs) != 0 || snapshot.ImageHref != "" {
+			return snapshot, errs
+		}
+
+		timer := time.NewTimer(opts.PollInterval)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, []error{ctx.Err()}
+		case <-timer.C:
+		}
+	}
+}
+
+// DownloadSnapshot synchronously downloads PNG image of rendered snapshot
+func (c *Client) DownloadSnapshot(s *Snapshot) ([]byte, []error) {
+	return c.DownloadSnapshotContext(context.Background(), s)
+}
+
+// DownloadSnapshotContext synchronously downloads PNG image of rendered snapshot
+// with given context
+func (c *Client) DownloadSnapshotContext(ctx context.Context, s *Snapshot) ([]byte, []error) {
+	if s == nil || s.ImageHref == "" {
+		return nil, []error{errors.New("Snapshot image is not rendered yet")}
+	}
+
+	engine := c.getEngine()
+
+	if engine == nil {
+		return nil, errEngineIsNil
+	}
+
+	userAgent := c.initEngine(engine)
+
+	if engine.Client == nil {
+		return nil, []error{req.ErrClientIsNil}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, req.GET, s.ImageHref, nil)
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	request.Header.Set("User-Agent", userAgent)
+
+	resp, err := engine.Client.Do(request)
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode > 299 {
+		return nil, extractErrors(resp.StatusCode, s.ImageHref, string(data))
+	}
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	return data, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// parseSnapshotID extracts snapshot ID from its URL
+func parseSnapshotID(href string) int64 {
+	id, _ := strconv.ParseInt(path.Base(href), 10, 64)
+	return id
+}
+
+// validateSnapshotOptions validate snapshot options struct
+func validateSnapshotOptions(o SnapshotOptions) error {
+	switch {
+	case o.ChartID < 0:
+		return errors.New("Snapshot property ChartID can't be negative")
+	case o.SpaceID < 0:
+		return errors.New("Snapshot property SpaceID can't be negative")
+	case o.ChartID == 0 && o.SpaceID == 0:
+		return errors.New("Snapshot must have ChartID or SpaceID property")
+	case o.ChartID != 0 && o.SpaceID != 0:
+		return errors.New("Snapshot can't have both ChartID and SpaceID properties")
+	case o.SpaceID != 0 && (o.ChartType != "" || o.Source != ""):
+		return errors.New("Snapshot properties ChartType and Source can't be used with SpaceID")
+	}
+
+	switch o.ChartType {
+	case "", CHART_TYPE_LINE, CHART_TYPE_STACKED, CHART_TYPE_BIGNUMBER:
+	default:
+		return errors.New("Snapshot property ChartType contains unsupported chart type")
+	}
+
+	if o.Duration < 0 {
+		return errors.New("Snapshot property Duration can't be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,344 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/essentialkaos/ek/v12/req"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Source contains source info
+type Source struct {
+	// Name of the source
+	Name string `json:"name"`
+
+	// Name which will be used for the source when viewing the Metrics website
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// ListSourcesOptions contains options of sources listing
+type ListSourcesOptions struct {
+	Pagination
+
+	// Name is search string used for filtering sources by name
+	Name string
+}
+
+// SourcesList contains page of sources list
+type SourcesList struct {
+	Query   QueryInfo `json:"query"`
+	Sources []*Source `json:"sources"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type sourceInfo struct {
+	DisplayName string `json:"display_name"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ListSources synchronously fetches list of sources from librato
+func ListSources(opts ListSourcesOptions) (*SourcesList, []error) {
+	return defaultClient.ListSources(opts)
+}
+
+// ListSourcesContext synchronously fetches list of sources from librato with
+// given context
+func ListSourcesContext(ctx context.Context, opts ListSourcesOptions) (*SourcesList, []error) {
+	return defaultClient.ListSourcesContext(ctx, opts)
+}
+
+// GetSource synchronously fetches source from librato
+func GetSource(name string) (*Source, []error) {
+	return defaultClient.GetSource(name)
+}
+
+// GetSourceContext synchronously fetches source from librato with given context
+func GetSourceContext(ctx context.Context, name string) (*Source, []error) {
+	return defaultClient.GetSourceContext(ctx, name)
+}
+
+// UpdateSource synchronously updates display name of source on librato
+func UpdateSource(s Source) []error {
+	return defaultClient.UpdateSource(s)
+}
+
+// UpdateSourceContext synchronously updates display name of source on librato
+// with given context
+func UpdateSourceContext(ctx context.Context, s Source) []error {
+	return defaultClient.UpdateSourceContext(ctx, s)
+}
+
+// DeleteSource synchronously removes source on librato
+func DeleteSource(name string) []error {
+	return defaultClient.DeleteSource(name)
+}
+
+// DeleteSourceContext synchronously removes source on librato with given context
+func DeleteSourceContext(ctx context.Context, name string) []error {
+	return defaultClient.DeleteSourceContext(ctx, name)
+}
+
+// ListInactiveSources synchronously fetches list of sources which haven't
+// reported measurements of any metric in given number of days
+func ListInactiveSources(days int) ([]*Source, []error) {
+	return defaultClient.ListInactiveSources(days)
+}
+
+// ListInactiveSourcesContext synchronously fetches list of sources which haven't
+// reported measurements of any metric in given number of days with given context
+func ListInactiveSourcesContext(ctx context.Context, days int) ([]*Source, []error) {
+	return defaultClient.ListInactiveSourcesContext(ctx, days)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ListSources synchronously fetches list of sources from librato
+func (c *Client) ListSources(opts ListSourcesOptions) (*SourcesList, []error) {
+	return c.ListSourcesContext(context.Background(), opts)
+}
+
+// ListSourcesContext synchronously fetches list of sources from librato with
+// given context
+func (c *Client) ListSourcesContext(ctx context.Context, opts ListSourcesOptions) (*SourcesList, []error) {
+	query := url.Values{}
+
+	opts.Pagination.apply(query)
+
+	if opts.Name != "" {
+		query.Set("name", opts.Name)
+	}
+
+	result := &SourcesList{}
+	errs := c.execJSONRequest(ctx, req.GET, "/v1/sources", query, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// GetSource synchronously fetches source from librato
+func (c *Client) GetSource(name string) (*Source, []error) {
+	return c.GetSourceContext(context.Background(), name)
+}
+
+// GetSourceContext synchronously fetches source from librato with given context
+func (c *Client) GetSourceContext(ctx context.Context, name string) (*Source, []error) {
+	if name == "" {
+		return nil, errEmptySourceName
+	}
+
+	result := &Source{}
+	errs := c.execJSONRequest(ctx, req.GET, getSourcePath(name), nil, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// UpdateSource synchronously updates display name of source on librato
+func (c *Client) UpdateSource(s Source) []error {
+	return c.UpdateSourceContext(context.Background(), s)
+}
+
+// UpdateSourceContext synchronously updates display name of source on librato
+// with given context
+func (c *Client) UpdateSourceContext(ctx context.Context, s Source) []error {
+	err := s.Validate()
+
+	if err != nil {
+		return []error{err}
+	}
+
+	return c.execJSONRequest(ctx, req.PUT, getSourcePath(s.Name), nil, sourceInfo{s.DisplayName}, nil)
+}
+
+// DeleteSource synchronously removes source on librato
+func (c *Client) DeleteSource(name string) []error {
+	return c.DeleteSourceContext(context.Background(), name)
+}
+
+// DeleteSourceContext synchronously removes source on librato with given context
+func (c *Client) DeleteSourceContext(ctx context.Context, name string) []error {
+	if name == "" {
+		return errEmptySourceName
+	}
+
+	return c.execJSONRequest(ctx, req.DELETE, getSourcePath(name), nil, nil, nil)
+}
+
+// ListInactiveSources synchronously fetches list of sources which haven't
+// reported measurements of any metric in given number of days
+func (c *Client) ListInactiveSources(days int) ([]*Source, []error) {
+	return c.ListInactiveSourcesContext(context.Background(), days)
+}
+
+// ListInactiveSourcesContext synchronously fetches list of sources which haven't
+// reported measurements of any metric in given number of days with given context
+func (c *Client) ListInactiveSourcesContext(ctx context.Context, days int) ([]*Source, []error) {
+	if days <= 0 {
+		return nil, []error{errors.New("Number of days must be greater than zero")}
+	}
+
+	sources, errs := c.listAllSources(ctx)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	metrics, errs := c.listAllMetrics(ctx)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	active := make(map[string]bool)
+
+	for _, metric := range metrics {
+		// Composite metrics can't be queried without compose expression and
+		// contain only data of other metrics
+		if metric.Type == METRIC_TYPE_COMPOSITE {
+			continue
+		}
+
+		errs = c.collectActiveSources(ctx, active, metric.Name, days)
+
+		if len(errs) != 0 {
+			return nil, errs
+		}
+	}
+
+	var result []*Source
+
+	for _, source := range sources {
+		if !active[source.Name] {
+			result = append(result, source)
+		}
+	}
+
+	return result, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Validate validates source struct
+func (s Source) Validate() error {
+	return validateSource(s)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// listAllSources fetches all pages of sources list
+func (c *Client) listAllSources(ctx context.Context) ([]*Source, []error) {
+	var result []*Source
+
+	opts := ListSourcesOptions{}
+
+	for {
+		list, errs := c.ListSourcesContext(ctx, opts)
+
+		if len(errs) != 0 {
+			return nil, errs
+		}
+
+		result = append(result, list.Sources...)
+
+		if len(list.Sources) == 0 || !list.Query.HasMore() {
+			return result, nil
+		}
+
+		opts.Pagination = opts.Pagination.Next(list.Query)
+	}
+}
+
+// listAllMetrics fetches all pages of metrics list
+func (c *Client) listAllMetrics(ctx context.Context) ([]*Metric, []error) {
+	var result []*Metric
+
+	opts := ListMetricsOptions{}
+
+	for {
+		list, errs := c.ListMetricsContext(ctx, opts)
+
+		if len(errs) != 0 {
+			return nil, errs
+		}
+
+		result = append(result, list.Metrics...)
+
+		if len(list.Metrics) == 0 || !list.Query.HasMore() {
+			return result, nil
+		}
+
+		opts.Pagination = opts.Pagination.Next(list.Query)
+	}
+}
+
+// collectActiveSources adds sources which reported measurements of metric in
+// given number of days to given set
+func (c *Client) collectActiveSources(ctx context.Context, result map[string]bool, metric string, days int) []error {
+	opts := QueryOptions{
+		Duration:   int64(days) * 86400,
+		Resolution: 86400,
+		UseSources: true,
+	}
+
+	for {
+		data, errs := c.QueryMeasurementsContext(ctx, metric, opts)
+
+		if len(errs) != 0 {
+			return errs
+		}
+
+		for _, series := range data.Series {
+			if len(series.Points) != 0 {
+				result[series.Source] = true
+			}
+		}
+
+		if data.NextTime == 0 {
+			return nil
+		}
+
+		opts = opts.Next(data)
+	}
+}
+
+// getSourcePath returns API path of source
+func getSourcePath(name string) string {
+	return "/v1/sources/" + url.PathEscape(name)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// validateSource validate source struct
+func validateSource(s Source) error {
+	if s.Name == "" {
+		return errors.New("Source property Name can't be empty")
+	}
+
+	if len(s.Name) > 255 {
+		return errors.New("Length of source property Name must be 255 or fewer characters")
+	}
+
+	if len(s.DisplayName) > 255 {
+		return errors.New("Length of source property DisplayName must be 255 or fewer characters")
+	}
+
+	return nil
+}
@@ -0,0 +1,680 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/essentialkaos/ek/v12/req"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Chart types
+const (
+	CHART_TYPE_LINE      = "line"
+	CHART_TYPE_STACKED   = "stacked"
+	CHART_TYPE_BIGNUMBER = "bignumber"
+)
+
+// Stream group functions
+const (
+	GROUP_FUNCTION_AVERAGE  = "average"
+	GROUP_FUNCTION_SUM      = "sum"
+	GROUP_FUNCTION_MIN      = "min"
+	GROUP_FUNCTION_MAX      = "max"
+	GROUP_FUNCTION_BREAKOUT = "breakout"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Space contains space (dashboard) info
+type Space struct {
+	// Unique ID of the space (set by API)
+	ID int64 `json:"id,omitempty"`
+
+	// Name of the space
+	Name string `json:"name"`
+
+	// List of charts (only IDs are returned by GetSpace)
+	Charts []*Chart `json:"charts,omitempty"`
+}
+
+// Chart contains chart definition
+type Chart struct {
+	// Unique ID of the chart (set by API)
+	ID int64 `json:"id,omitempty"`
+
+	// Title of the chart
+	Name string `json:"name"`
+
+	// Type of the chart (line, stacked or bignumber)
+	Type string `json:"type,omitempty"`
+
+	// List of data streams displayed on the chart
+	Streams []*ChartStream `json:"streams,omitempty"`
+
+	// Minimum and maximum values of the Y-axis
+	Min interface{} `json:"min,omitempty"`
+	Max interface{} `json:"max,omitempty"`
+
+	// Label of the Y-axis
+	Label string `json:"label,omitempty"`
+
+	// ID of space which is opened by click on the chart
+	RelatedSpace int64 `json:"related_space,omitempty"`
+
+	// If true, bignumber chart shows the last value instead of summary
+	UseLastValue bool `json:"use_last_value,omitempty"`
+
+	// List of thresholds displayed on the chart
+	Thresholds []*ChartThreshold `json:"thresholds,omitempty"`
+}
+
+// ChartStream contains data stream of chart
+type ChartStream struct {
+	// Unique ID of the stream (set by API)
+	ID int64 `json:"id,omitempty"`
+
+	// Name of the metric (can't be used with Composite)
+	Metric string `json:"metric,omitempty"`
+
+	// Source of the metric (wildcards are supported, only for source-based metrics)
+	Source string `json:"source,omitempty"`
+
+	// Tags used for filtering measurements (only for tagged metrics)
+	Tags []*ChartStreamTag `json:"tags,omitempty"`
+
+	// Composite metric expression (can't be used with Metric)
+	Composite CompositeExpr `json:"composite,omitempty"`
+
+	// Display name of the stream
+	Name string `json:"name,omitempty"`
+
+	// Function used for grouping series (average, sum, min, max or breakout)
+	GroupFunction string `json:"group_function,omitempty"`
+
+	// Function used for summarizing data (average, sum, count, min or max)
+	SummaryFunction string `json:"summary_function,omitempty"`
+
+	// Function used for downsampling data (average, sum, count, min or max)
+	DownsampleFunction string `json:"downsample_function,omitempty"`
+
+	// Color used for the stream (#RRGGBB)
+	Color string `json:"color,omitempty"`
+
+	// Short and long names of measurement units
+	UnitsShort string `json:"units_short,omitempty"`
+	UnitsLong  string `json:"units_long,omitempty"`
+
+	// Minimum and maximum displayed values
+	Min interface{} `json:"min,omitempty"`
+	Max interface{} `json:"max,omitempty"`
+
+	// Transform function applied to values (e.g. "x/1024")
+	TransformFunction string `json:"transform_function,omitempty"`
+
+	// Period of the metric in seconds
+	Period int `json:"period,omitempty"`
+}
+
+// ChartStreamTag contains tag filter of chart stream
+type ChartStreamTag struct {
+	// Name of the tag
+	Name string `json:"name"`
+
+	// List of tag values (wildcards are supported)
+	Values []string `json:"values,omitempty"`
+
+	// If true, tag value can be selected in space
+	Dynamic bool `json:"dynamic,omitempty"`
+}
+
+// ChartThreshold contains threshold displayed on chart
+type ChartThreshold struct {
+	// Threshold value
+	Value float64 `json:"value"`
+
+	// Color of the threshold (#RRGGBB)
+	Color string `json:"color,omitempty"`
+
+	// Label of the threshold
+	Label string `json:"label,omitempty"`
+}
+
+// ListSpacesOptions contains options of spaces listing
+type ListSpacesOptions struct {
+	Pagination
+
+	// Name is search string used for filtering spaces by name
+	Name string
+}
+
+// SpacesList contains page of spaces list
+type SpacesList struct {
+	Query  QueryInfo `json:"query"`
+	Spaces []*Space  `json:"spaces"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type spacePayload struct {
+	Name string `json:"name"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ListSpaces synchronously fetches list of spaces from librato
+func ListSpaces(opts ListSpacesOptions) (*SpacesList, []error) {
+	return defaultClient.ListSpaces(opts)
+}
+
+// ListSpacesContext synchronously fetches list of spaces from librato with
+// given context
+func ListSpacesContext(ctx context.Context, opts ListSpacesOptions) (*SpacesList, []error) {
+	return defaultClient.ListSpacesContext(ctx, opts)
+}
+
+// GetSpace synchronously fetches space from librato
+func GetSpace(id int64) (*Space, []error) {
+	return defaultClient.GetSpace(id)
+}
+
+// GetSpaceContext synchronously fetches space from librato with given context
+func GetSpaceContext(ctx context.Context, id int64) (*Space, []error) {
+	return defaultClient.GetSpaceContext(ctx, id)
+}
+
+// CreateSpace synchronously creates space on librato
+func CreateSpace(s Space) (*Space, []error) {
+	return defaultClient.CreateSpace(s)
+}
+
+// CreateSpaceContext synchronously creates space on librato with given context
+func CreateSpaceContext(ctx context.Context, s Space) (*Space, []error) {
+	return defaultClient.CreateSpaceContext(ctx, s)
+}
+
+// UpdateSpace synchronously updates space name on librato
+func UpdateSpace(s Space) []error {
+	return defaultClient.UpdateSpace(s)
+}
+
+// UpdateSpaceContext synchronously updates space name on librato with given
+// context
+func UpdateSpaceContext(ctx context.Context, s Space) []error {
+	return defaultClient.UpdateSpaceContext(ctx, s)
+}
+
+// DeleteSpace synchronously removes space with all charts on librato
+func DeleteSpace(id int64) []error {
+	return defaultClient.DeleteSpace(id)
+}
+
+// DeleteSpaceContext synchronously removes space with all charts on librato
+// with given context
+func DeleteSpaceContext(ctx context.Context, id int64) []error {
+	return defaultClient.DeleteSpaceContext(ctx, id)
+}
+
+// ListCharts synchronously fetches all charts of space from librato
+func ListCharts(spaceID int64) ([]*Chart, []error) {
+	return defaultClient.ListCharts(spaceID)
+}
+
+// ListChartsContext synchronously fetches all charts of space from librato
+// with given context
+func ListChartsContext(ctx context.Context, spaceID int64) ([]*Chart, []error) {
+	return defaultClient.ListChartsContext(ctx, spaceID)
+}
+
+// GetChart synchronously fetches chart from librato
+func GetChart(spaceID, chartID int64) (*Chart, []error) {
+	return defaultClient.GetChart(spaceID, chartID)
+}
+
+// GetChartContext synchronously fetches chart from librato with given context
+func GetChartContext(ctx context.Context, spaceID, chartID int64) (*Chart, []error) {
+	return defaultClient.GetChartContext(ctx, spaceID, chartID)
+}
+
+// CreateChart synchronously creates chart in space on librato
+func CreateChart(spaceID int64, ch Chart) (*Chart, []error) {
+	return defaultClient.CreateChart(spaceID, ch)
+}
+
+// CreateChartContext synchronously creates chart in space on librato with
+// given context
+func CreateChartContext(ctx context.Context, spaceID int64, ch Chart) (*Chart, []error) {
+	return defaultClient.CreateChartContext(ctx, spaceID, ch)
+}
+
+// UpdateChart synchronously updates chart on librato
+func UpdateChart(spaceID int64, ch Chart) []error {
+	return defaultClient.UpdateChart(spaceID, ch)
+}
+
+// UpdateChartContext synchronously updates chart on librato with given context
+func UpdateChartContext(ctx context.Context, spaceID int64, ch Chart) []error {
+	return defaultClient.UpdateChartContext(ctx, spaceID, ch)
+}
+
+// DeleteChart synchronously removes chart on librato
+func DeleteChart(spaceID, chartID int64) []error {
+	return defaultClient.DeleteChart(spaceID, chartID)
+}
+
+// DeleteChartContext synchronously removes chart on librato with given context
+func DeleteChartContext(ctx context.Context, spaceID, chartID int64) []error {
+	return defaultClient.DeleteChartContext(ctx, spaceID, chartID)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ListSpaces synchronously fetches list of spaces from librato
+func (c *Client) ListSpaces(opts ListSpacesOptions) (*SpacesList, []error) {
+	return c.ListSpacesContext(context.Background(), opts)
+}
+
+// ListSpacesContext synchronously fetches list of spaces from librato with
+// given context
+func (c *Client) ListSpacesContext(ctx context.Context, opts ListSpacesOptions) (*SpacesList, []error) {
+	query := url.Values{}
+
+	opts.Pagination.apply(query)
+
+	if opts.Name != "" {
+		query.Set("name", opts.Name)
+	}
+
+	result := &SpacesList{}
+	errs := c.execJSONRequest(ctx, req.GET, "/v1/spaces", query, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// GetSpace synchronously fetches space from librato
+func (c *Client) GetSpace(id int64) (*Space, []error) {
+	return c.GetSpaceContext(context.Background(), id)
+}
+
+// GetSpaceContext synchronously fetches space from librato with given context
+func (c *Client) GetSpaceContext(ctx context.Context, id int64) (*Space, []error) {
+	if id <= 0 {
+		return nil, errInvalidSpaceID
+	}
+
+	result := &Space{}
+	errs := c.execJSONRequest(ctx, req.GET, getSpacePath(id), nil, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// CreateSpace synchronously creates space on librato
+func (c *Client) CreateSpace(s Space) (*Space, []error) {
+	return c.CreateSpaceContext(context.Background(), s)
+}
+
+// CreateSpaceContext synchronously creates space on librato with given context
+func (c *Client) CreateSpaceContext(ctx context.Context, s Space) (*Space, []error) {
+	err := s.Validate()
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	result := &Space{}
+	errs := c.execJSONRequest(ctx, req.POST, "/v1/spaces", nil, spacePayload{s.Name}, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// UpdateSpace synchronously updates space name on librato
+func (c *Client) UpdateSpace(s Space) []error {
+	return c.UpdateSpaceContext(context.Background(), s)
+}
+
+// UpdateSpaceContext synchronously updates space name on librato with given
+// context
+func (c *Client) UpdateSpaceContext(ctx context.Context, s Space) []error {
+	if s.ID <= 0 {
+		return errInvalidSpaceID
+	}
+
+	err := s.Validate()
+
+	if err != nil {
+		return []error{err}
+	}
+
+	return c.execJSONRequest(ctx, req.PUT, getSpacePath(s.ID), nil, spacePayload{s.Name}, nil)
+}
+
+// DeleteSpace synchronously removes space with all charts on librato
+func (c *Client) DeleteSpace(id int64) []error {
+	return c.DeleteSpaceContext(context.Background(), id)
+}
+
+// DeleteSpaceContext synchronously removes space with all charts on librato
+// with given context
+func (c *Client) DeleteSpaceContext(ctx context.Context, id int64) []error {
+	if id <= 0 {
+		return errInvalidSpaceID
+	}
+
+	return c.execJSONRequest(ctx, req.DELETE, getSpacePath(id), nil, nil, nil)
+}
+
+// ListCharts synchronously fetches all charts of space from librato
+func (c *Client) ListCharts(spaceID int64) ([]*Chart, []error) {
+	return c.ListChartsContext(context.Background(), spaceID)
+}
+
+// ListChartsContext synchronously fetches all charts of space from librato
+// with given context
+func (c *Client) ListChartsContext(ctx context.Context, spaceID int64) ([]*Chart, []error) {
+	if spaceID <= 0 {
+		return nil, errInvalidSpaceID
+	}
+
+	var result []*Chart
+
+	errs := c.execJSONRequest(ctx, req.GET, getSpacePath(spaceID)+"/charts", nil, nil, &result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// GetChart synchronously fetches chart from librato
+func (c *Client) GetChart(spaceID, chartID int64) (*Chart, []error) {
+	return c.GetChartContext(context.Background(), spaceID, chartID)
+}
+
+// GetChartContext synchronously fetches chart from librato with given context
+func (c *Client) GetChartContext(ctx context.Context, spaceID, chartID int64) (*Chart, []error) {
+	switch {
+	case spaceID <= 0:
+		return nil, errInvalidSpaceID
+	case chartID <= 0:
+		return nil, errInvalidChartID
+	}
+
+	result := &Chart{}
+	errs := c.execJSONRequest(ctx, req.GET, getChartPath(spaceID, chartID), nil, nil, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// CreateChart synchronously creates chart in space on librato
+func (c *Client) CreateChart(spaceID int64, ch Chart) (*Chart, []error) {
+	return c.CreateChartContext(context.Background(), spaceID, ch)
+}
+
+// CreateChartContext synchronously creates chart in space on librato with
+// given context
+func (c *Client) CreateChartContext(ctx context.Context, spaceID int64, ch Chart) (*Chart, []error) {
+	if spaceID <= 0 {
+		return nil, errInvalidSpaceID
+	}
+
+	err := ch.Validate()
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	ch.ID = 0
+
+	result := &Chart{}
+	errs := c.execJSONRequest(ctx, req.POST, getSpacePath(spaceID)+"/charts", nil, ch, result)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// UpdateChart synchronously updates chart on librato
+func (c *Client) UpdateChart(spaceID int64, ch Chart) []error {
+	return c.UpdateChartContext(context.Background(), spaceID, ch)
+}
+
+// UpdateChartContext synchronously updates chart on librato with given context
+func (c *Client) UpdateChartContext(ctx context.Context, spaceID int64, ch Chart) []error {
+	switch {
+	case spaceID <= 0:
+		return errInvalidSpaceID
+	case ch.ID <= 0:
+		return errInvalidChartID
+	}
+
+	err := ch.Validate()
+
+	if err != nil {
+		return []error{err}
+	}
+
+	return c.execJSONRequest(ctx, req.PUT, getChartPath(spaceID, ch.ID), nil, ch, nil)
+}
+
+// DeleteChart synchronously removes chart on librato
+func (c *Client) DeleteChart(spaceID, chartID int64) []error {
+	return c.DeleteChartContext(context.Background(), spaceID, chartID)
+}
+
+// DeleteChartContext synchronously removes chart on librato with given context
+func (c *Client) DeleteChartContext(ctx context.Context, spaceID, chartID int64) []error {
+	switch {
+	case spaceID <= 0:
+		return errInvalidSpaceID
+	case chartID <= 0:
+		return errInvalidChartID
+	}
+
+	return c.execJSONRequest(ctx, req.DELETE, getChartPath(spaceID, chartID), nil, nil, nil)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewChartStream creates chart stream for metric with name and source or tags
+// of given measurement
+func NewChartStream(m Measurement) *ChartStream {
+	switch u := m.(type) {
+	case Gauge:
+		return &ChartStream{Metric: u.Name, Source: u.Source}
+	case Counter:
+		return &ChartStream{Metric: u.Name, Source: u.Source}
+	case TaggedMeasurement:
+		stream := &ChartStream{Metric: u.Name}
+		names := make([]string, 0, len(u.Tags))
+
+		for name := range u.Tags {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			stream.Tags = append(stream.Tags, &ChartStreamTag{
+				Name: name, Values: []string{u.Tags[name]},
+			})
+		}
+
+		return stream
+	}
+
+	return nil
+}
+
+// Validate validates space struct
+func (s Space) Validate() error {
+	return validateSpace(s)
+}
+
+// Validate validates chart struct
+func (ch Chart) Validate() error {
+	return validateChart(ch)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getSpacePath returns API path of space
+func getSpacePath(id int64) string {
+	return "/v1/spaces/" + strconv.FormatInt(id, 10)
+}
+
+// getChartPath returns API path of chart
+func getChartPath(spaceID, chartID int64) string {
+	return getSpacePath(spaceID) + "/charts/" + strconv.FormatInt(chartID, 10)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// validateSpace validate space struct
+func validateSpace(s Space) error {
+	if s.Name == "" {
+		return errors.New("Space property Name can't be empty")
+	}
+
+	return nil
+}
+
+// validateChart validate chart struct
+func validateChart(ch Chart) error {
+	if ch.Name == "" {
+		return errors.New("Chart property Name can't be empty")
+	}
+
+	switch ch.Type {
+	case "", CHART_TYPE_LINE, CHART_TYPE_STACKED:
+	case CHART_TYPE_BIGNUMBER:
+		if len(ch.Streams) > 1 {
+			return errors.New("Bignumber chart can have only one stream")
+		}
+	default:
+		return errors.New("Chart property Type contains unsupported chart type")
+	}
+
+	if ch.UseLastValue && ch.Type != CHART_TYPE_BIGNUMBER {
+		return errors.New("Chart property UseLastValue can be used only with bignumber charts")
+	}
+
+	if len(ch.Streams) == 0 {
+		return errors.New("Chart must have at least one stream")
+	}
+
+	if !isNumericOrNil(ch.Min) {
+		return errors.New("Chart property Min can't be non-numeric")
+	}
+
+	if !isNumericOrNil(ch.Max) {
+		return errors.New("Chart property Max can't be non-numeric")
+	}
+
+	for _, stream := range ch.Streams {
+		err := validateChartStream(stream)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, t := range ch.Thresholds {
+		if t == nil {
+			return errors.New("Chart threshold can't be nil")
+		}
+
+		if t.Color != "" && !colorRegexp.MatchString(t.Color) {
+			return errors.New("Chart threshold property Color must be in #RRGGBB format")
+		}
+	}
+
+	return nil
+}
+
+// validateChartStream validate chart stream struct
+func validateChartStream(s *ChartStream) error {
+	if s == nil {
+		return errors.New("Chart stream can't be nil")
+	}
+
+	switch {
+	case s.Metric == "" && s.Composite == "":
+		return errors.New("Chart stream must have Metric or Composite property")
+	case s.Metric != "" && s.Composite != "":
+		return errors.New("Chart stream can't have both Metric and Composite properties")
+	case s.Composite != "" && (s.Source != "" || len(s.Tags) != 0):
+		return errors.New("Chart stream with Composite property can't have Source or Tags")
+	case s.Source != "" && len(s.Tags) != 0:
+		return errors.New("Chart stream can't have both Source and Tags")
+	}
+
+	switch s.GroupFunction {
+	case "", GROUP_FUNCTION_AVERAGE, GROUP_FUNCTION_SUM, GROUP_FUNCTION_MIN,
+		GROUP_FUNCTION_MAX, GROUP_FUNCTION_BREAKOUT:
+	default:
+		return errors.New("Chart stream property GroupFunction contains unsupported function")
+	}
+
+	for _, fn := range []string{s.SummaryFunction, s.DownsampleFunction} {
+		switch fn {
+		case "", SUMMARIZE_AVERAGE, SUMMARIZE_SUM, SUMMARIZE_COUNT,
+			SUMMARIZE_MIN, SUMMARIZE_MAX:
+		default:
+			return errors.New("Chart stream property SummaryFunction or DownsampleFunction contains unsupported function")
+		}
+	}
+
+	if s.Color != "" && !colorRegexp.MatchString(s.Color) {
+		return errors.New("Chart stream property Color must be in #RRGGBB format")
+	}
+
+	if !isNumericOrNil(s.Min) {
+		return errors.New("Chart stream property Min can't be non-numeric")
+	}
+
+	if !isNumericOrNil(s.Max) {
+		return errors.New("Chart stream property Max can't be non-numeric")
+	}
+
+	for _, tag := range s.Tags {
+		if tag == nil || tag.Name == "" {
+			return errors.New("Chart stream tag property Name can't be empty")
+		}
+
+		if len(tag.Values) == 0 && !tag.Dynamic {
+			return errors.New("Chart stream tag must have Values or be dynamic")
+		}
+	}
+
+	return nil
+}
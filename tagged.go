@@ -0,0 +1,293 @@
+package librato
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                         Copyright (c) 2022 ESSENTIAL KAOS                          //
+//      Apache License, Version 2.0 <https://www.apache.org/licenses/LICENSE-2.0>     //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+const (
+	// MAX_TAGS is maximum number of tags per measurement
+	MAX_TAGS = 50
+
+	// MAX_TAG_NAME_LENGTH is maximum length of tag name
+	MAX_TAG_NAME_LENGTH = 64
+
+	// MAX_TAG_VALUE_LENGTH is maximum length of tag value
+	MAX_TAG_VALUE_LENGTH = 255
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Tags is map with measurement tags
+type Tags map[string]string
+
+// TaggedMeasurement is measurement for tagged measurements API
+type TaggedMeasurement struct {
+
+	// Each metric has a name that is unique to its class of metrics. The name can be
+	// up to 255 characters in length. Valid characters for metric names are
+	// 'A-Za-z0-9.:-_'. The metric namespace is case insensitive.
+	Name string `json:"name"`
+
+	// The numeric value of an individual measurement. The value parameter should
+	// not be set if count is set.
+	Value interface{} `json:"value,omitempty"`
+
+	// The epoch time at which an individual measurement occurred with a maximum
+	// resolution of seconds. By default this is set to the current time.
+	Time int64 `json:"time,omitempty"`
+
+	// A set of key/value pairs that describe the particular data stream. Tags
+	// set for measurement override top-level tags of payload with the same name.
+	Tags Tags `json:"tags,omitempty"`
+
+	// Indicates the request corresponds to a multi-sample measurement. If count
+	// is set, then sum must also be set in order to calculate an average value
+	// for the recorded metric measurement.
+	Count interface{} `json:"count,omitempty"`
+
+	// If count was set, sum must be set to the summation of the individual
+	// measurements.
+	Sum interface{} `json:"sum,omitempty"`
+
+	// If count was set, min can be used to report the smallest individual
+	// measurement amongst the averaged set.
+	Min interface{} `json:"min,omitempty"`
+
+	// If count was set, max can be used to report the largest individual
+	// measurement amongst the averaged set.
+	Max interface{} `json:"max,omitempty"`
+
+	// If count was set, last can be used to report the last individual
+	// measurement amongst the averaged set.
+	Last interface{} `json:"last,omitempty"`
+
+	// If count was set, stddev can be used to report the standard deviation
+	// of the averaged set.
+	StdDev interface{} `json:"stddev,omitempty"`
+
+	// Period defines reporting interval (in seconds) of the measurement.
+	Period int64 `json:"period,omitempty"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+type taggedMeasurements struct {
+	Tags         Tags                `json:"tags,omitempty"`
+	Measurements []TaggedMeasurement `json:"measurements"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var (
+	tagNameRegexp  = regexp.MustCompile(`^[-.:_\w]+$`)
+	tagValueRegexp = regexp.MustCompile(`^[-.:_\\/\w ]+$`)
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// AddMeasurements synchronously send tagged measurements to librato
+func AddMeasurements(tags Tags, m ...TaggedMeasurement) []error {
+	return defaultClient.AddMeasurements(tags, m...)
+}
+
+// AddMeasurementsContext synchronously send tagged measurements to librato
+// with given context
+func AddMeasurementsContext(ctx context.Context, tags Tags, m ...TaggedMeasurement) []error {
+	return defaultClient.AddMeasurementsContext(ctx, tags, m...)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// AddMeasurements synchronously send tagged measurements to librato
+func (c *Client) AddMeasurements(tags Tags, m ...TaggedMeasurement) []error {
+	return c.AddMeasurementsContext(context.Background(), tags, m...)
+}
+
+// AddMeasurementsContext synchronously send tagged measurements to librato
+// with given context
+func (c *Client) AddMeasurementsContext(ctx context.Context, tags Tags, m ...TaggedMeasurement) []error {
+	var errs []error
+
+	err := validateTags(tags)
+
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, metric := range m {
+		err = metric.Validate()
+
+		if err == nil {
+			err = validateMeasurementTags(metric, tags)
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+
+	data := make([]Measurement, 0, len(m))
+
+	for _, metric := range m {
+		data = append(data, metric)
+	}
+
+	errs, _, _ = c.sendMeasurements(ctx, data, sendOptions{
+		engine: c.getEngine(),
+		policy: c.getRetryPolicy(),
+		tags:   tags,
+	})
+
+	return errs
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Validate validates tagged measurement struct
+func (m TaggedMeasurement) Validate() error {
+	return validateTaggedMeasurement(m)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// validateTaggedMeasurement validate tagged measurement struct
+func validateTaggedMeasurement(m TaggedMeasurement) error {
+	if m.Name == "" {
+		return errors.New("Measurement property Name can't be empty")
+	}
+
+	if len(m.Name) > 255 {
+		return errors.New("Length of measurement property Name must be 255 or fewer characters")
+	}
+
+	if m.Count == nil {
+		if !isNumeric(m.Value) {
+			return errors.New("Measurement property Value can't be non-numeric")
+		}
+	} else {
+		if m.Value != nil {
+			return errors.New("Measurement property Value can't be set if Count is set")
+		}
+
+		if m.Sum == nil {
+			return errors.New("Measurement property Sum must be set if Count is set")
+		}
+
+		count, hasCount := toFloat(m.Count)
+
+		if hasCount && count <= 0 {
+			return errors.New("Measurement property Count must be greater than zero")
+		}
+	}
+
+	switch {
+	case !isNumericOrNil(m.Count):
+		return errors.New("Measurement property Count can't be non-numeric")
+	case !isNumericOrNil(m.Sum):
+		return errors.New("Measurement property Sum can't be non-numeric")
+	case !isNumericOrNil(m.Min):
+		return errors.New("Measurement property Min can't be non-numeric")
+	case !isNumericOrNil(m.Max):
+		return errors.New("Measurement property Max can't be non-numeric")
+	case !isNumericOrNil(m.Last):
+		return errors.New("Measurement property Last can't be non-numeric")
+	case !isNumericOrNil(m.StdDev):
+		return errors.New("Measurement property StdDev can't be non-numeric")
+	}
+
+	return validateTags(m.Tags)
+}
+
+// validateMeasurementTags checks that tagged measurement has own tags or
+// top-level tags are set
+func validateMeasurementTags(m Measurement, tags Tags) error {
+	tm, ok := m.(TaggedMeasurement)
+
+	if !ok || len(tm.Tags) != 0 || len(tags) != 0 {
+		return nil
+	}
+
+	return fmt.Errorf("Measurement %q must have tags if top-level tags are not set", tm.Name)
+}
+
+// filterUntaggedMeasurements removes tagged measurements without tags from
+// given slice and returns errors for every removed measurement
+func filterUntaggedMeasurements(data []Measurement, tags Tags) ([]Measurement, []error) {
+	if len(tags) != 0 {
+		return data, nil
+	}
+
+	var errs []error
+
+	result := make([]Measurement, 0, len(data))
+
+	for _, m := range data {
+		err := validateMeasurementTags(m, tags)
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		result = append(result, m)
+	}
+
+	return result, errs
+}
+
+// validateTags validate tags names and values
+func validateTags(tags Tags) error {
+	if len(tags) > MAX_TAGS {
+		return fmt.Errorf("Number of tags must be %d or fewer", MAX_TAGS)
+	}
+
+	for name, value := range tags {
+		switch {
+		case name == "":
+			return errors.New("Tag name can't be empty")
+		case len(name) > MAX_TAG_NAME_LENGTH:
+			return fmt.Errorf("Length of tag name %q must be %d or fewer characters", name, MAX_TAG_NAME_LENGTH)
+		case !tagNameRegexp.MatchString(name):
+			return fmt.Errorf("Tag name %q contains invalid characters", name)
+		case value == "":
+			return fmt.Errorf("Value of tag %q can't be empty", name)
+		case len(value) > MAX_TAG_VALUE_LENGTH:
+			return fmt.Errorf("Length of tag %q value must be %d or fewer characters", name, MAX_TAG_VALUE_LENGTH)
+		case !tagValueRegexp.MatchString(value):
+			return fmt.Errorf("Value of tag %q contains invalid characters", name)
+		}
+	}
+
+	return nil
+}
+
+// isNumeric returns true if given value is numeric
+func isNumeric(v interface{}) bool {
+	switch v.(type) {
+	case int, int32, int64, uint, uint32, uint64, float32, float64:
+		return true
+	}
+
+	return false
+}
+
+// isNumericOrNil returns true if given value is numeric or nil
+func isNumericOrNil(v interface{}) bool {
+	return v == nil || isNumeric(v)
+}